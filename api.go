@@ -0,0 +1,279 @@
+package vpnc
+import (
+	"bytes"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// HTTP API for managing VPN clients. All requests must be authorized by one of Tokens
+// in header "Authorization: Bearer <token>" or "X-API-Token: <token>".
+//
+//   GET    /clients                  - list of clients
+//   POST   /clients                  - create client: {"name": "ivan", "owner": "...", "email": "...", "team": "...", "notes": "..."}
+//   GET    /clients/{name}           - client info
+//   DELETE /clients/{name}           - revoke client certificate and remove static IP (410 if already revoked)
+//   GET    /clients/{name}/bundle    - download client bundle (?format=zip|tar.gz|ovpn&platform=windows)
+//   PUT    /clients/{name}/ip        - set static IP: {"ip": "10.8.0.10", "ipv6": "fd00::10"}
+//   DELETE /clients/{name}/ip        - remove static IP
+//
+// Errors are returned as JSON: {"error": "message"}
+type APIServer struct {
+	Server    OpenVPNServer // Server configuration
+	RSA       EasyRSA       // Easy-rsa of server
+	Addresses []string      // Public addresses of server for client configurations. Optional (Server.Addresses)
+	Tokens    []string      // Accepted API tokens. Required: API denies all requests without tokens
+}
+
+// Client description in API. Same as ClientInfo returned by ListClients
+type APIClient = ClientInfo
+
+type apiError struct {
+	Error string `json:"error"`
+}
+
+type apiStatusError struct {
+	status  int
+	message string
+}
+
+func (ae *apiStatusError) Error() string {
+	return ae.message
+}
+
+func apiErrorf(status int, message string) error {
+	return &apiStatusError{status: status, message: message}
+}
+
+func (api *APIServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !api.authorized(r) {
+		writeAPIError(w, apiErrorf(http.StatusUnauthorized, "invalid or missing API token"))
+		return
+	}
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if parts[0] != "clients" || len(parts) > 3 {
+		writeAPIError(w, apiErrorf(http.StatusNotFound, "not found"))
+		return
+	}
+	var err error
+	switch {
+	case len(parts) == 1 && r.Method == "GET":
+		err = api.listClients(w)
+	case len(parts) == 1 && r.Method == "POST":
+		err = api.createClient(w, r)
+	case len(parts) == 2 && r.Method == "GET":
+		err = api.getClient(w, parts[1])
+	case len(parts) == 2 && r.Method == "DELETE":
+		err = api.revokeClient(w, parts[1])
+	case len(parts) == 3 && parts[2] == "bundle" && r.Method == "GET":
+		err = api.downloadBundle(w, r, parts[1])
+	case len(parts) == 3 && parts[2] == "ip" && r.Method == "PUT":
+		err = api.setStaticIP(w, r, parts[1])
+	case len(parts) == 3 && parts[2] == "ip" && r.Method == "DELETE":
+		err = api.removeStaticIP(w, parts[1])
+	case len(parts) <= 3:
+		err = apiErrorf(http.StatusMethodNotAllowed, "method not allowed")
+	}
+	if err != nil {
+		writeAPIError(w, err)
+	}
+}
+
+func (api *APIServer) authorized(r *http.Request) bool {
+	token := r.Header.Get("X-API-Token")
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		token = strings.TrimPrefix(auth, "Bearer ")
+	}
+	if token == "" {
+		return false
+	}
+	for _, t := range api.Tokens {
+		if subtle.ConstantTimeCompare([]byte(t), []byte(token)) == 1 {
+			return true
+		}
+	}
+	return false
+}
+
+func (api *APIServer) clients() ([]ClientInfo, error) {
+	return ListClients(api.Server, api.RSA)
+}
+
+func (api *APIServer) client(name string) (ClientInfo, error) {
+	clients, err := api.clients()
+	if err != nil {
+		return ClientInfo{}, err
+	}
+	for _, c := range clients {
+		if c.Name == name {
+			return c, nil
+		}
+	}
+	return ClientInfo{}, apiErrorf(http.StatusNotFound, "client " + name + " not found")
+}
+
+func (api *APIServer) listClients(w http.ResponseWriter) error {
+	clients, err := api.clients()
+	if err != nil {
+		return err
+	}
+	if clients == nil {
+		clients = []ClientInfo{}
+	}
+	return writeJSON(w, http.StatusOK, clients)
+}
+
+func (api *APIServer) getClient(w http.ResponseWriter, name string) error {
+	c, err := api.client(name)
+	if err != nil {
+		return err
+	}
+	return writeJSON(w, http.StatusOK, c)
+}
+
+func (api *APIServer) createClient(w http.ResponseWriter, r *http.Request) error {
+	var req struct {
+		Name  string `json:"name"`
+		Owner string `json:"owner"`
+		Email string `json:"email"`
+		Team  string `json:"team"`
+		Notes string `json:"notes"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return apiErrorf(http.StatusBadRequest, "bad request: " + err.Error())
+	}
+	if req.Name == "" {
+		return apiErrorf(http.StatusBadRequest, "client name is required")
+	}
+	_, err := api.RSA.ProvisionClient(req.Name, func(keys ClientKeyFiles) error {
+		return api.RSA.Registry().Update(req.Name, func(rec *ClientRecord) {
+			rec.Owner, rec.Email, rec.Team, rec.Notes = req.Owner, req.Email, req.Team, req.Notes
+		})
+	})
+	if err != nil {
+		return err
+	}
+	c, err := api.client(req.Name)
+	if err != nil {
+		return err
+	}
+	return writeJSON(w, http.StatusCreated, c)
+}
+
+func (api *APIServer) revokeClient(w http.ResponseWriter, name string) error {
+	c, err := api.client(name)
+	if err != nil {
+		return err
+	}
+	if c.Status == "revoked" {
+		return apiErrorf(http.StatusGone, "client " + name + " is already revoked")
+	}
+	if err := api.RSA.RevokeClient(name); err != nil {
+		return err
+	}
+	if api.Server.PersistIPFile != "" {
+		if err := api.Server.RemoveStaticIP(name); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+func (api *APIServer) downloadBundle(w http.ResponseWriter, r *http.Request, name string) error {
+	c, err := api.client(name)
+	if err != nil {
+		return err
+	}
+	if c.Status != "valid" {
+		return apiErrorf(http.StatusGone, "client " + name + " is " + c.Status)
+	}
+	format, err := ParseArchiveFormat(r.URL.Query().Get("format"))
+	if err != nil {
+		return apiErrorf(http.StatusBadRequest, err.Error())
+	}
+	platform, err := ParsePlatform(r.URL.Query().Get("platform"))
+	if err != nil {
+		return apiErrorf(http.StatusBadRequest, err.Error())
+	}
+	ovpn := api.Server
+	ovpn.ClientPlatform = platform
+	if len(api.Addresses) > 0 {
+		ovpn.Addresses = api.Addresses
+	}
+	if err = ovpn.checkClientFields(); err != nil {
+		return err
+	}
+	// bundle is buffered to report errors before headers are sent
+	buf := &bytes.Buffer{}
+	if err = WriteClientBundle(buf, format, ovpn, api.RSA.ClientKeys(name)); err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", format.ContentType())
+	w.Header().Set("Content-Disposition", "attachment; filename=\"" + name + format.Extension() + "\"")
+	_, err = w.Write(buf.Bytes())
+	return err
+}
+
+func (api *APIServer) setStaticIP(w http.ResponseWriter, r *http.Request, name string) error {
+	var req struct {
+		IP   string `json:"ip"`
+		IPv6 string `json:"ipv6"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return apiErrorf(http.StatusBadRequest, "bad request: " + err.Error())
+	}
+	if req.IP == "" {
+		return apiErrorf(http.StatusBadRequest, "ip is required")
+	}
+	if api.Server.PersistIPFile == "" {
+		return apiErrorf(http.StatusNotImplemented, "static IPs are not configured")
+	}
+	if _, err := api.client(name); err != nil {
+		return err
+	}
+	if err := api.Server.SetStaticIP(StaticIP{Client: name, IPv4: req.IP, IPv6: req.IPv6}); err != nil {
+		return err
+	}
+	c, err := api.client(name)
+	if err != nil {
+		return err
+	}
+	return writeJSON(w, http.StatusOK, c)
+}
+
+func (api *APIServer) removeStaticIP(w http.ResponseWriter, name string) error {
+	if api.Server.PersistIPFile == "" {
+		return apiErrorf(http.StatusNotImplemented, "static IPs are not configured")
+	}
+	if err := api.Server.RemoveStaticIP(name); err != nil {
+		return err
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, value interface{}) error {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	return json.NewEncoder(w).Encode(value)
+}
+
+func writeAPIError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	var se *apiStatusError
+	switch {
+	case errors.As(err, &se):
+		status = se.status
+	case errors.Is(err, ErrClientExists):
+		status = http.StatusConflict
+	case errors.Is(err, ErrInvalidClientName), errors.Is(err, ErrInvalidIP):
+		status = http.StatusBadRequest
+	case errors.Is(err, ErrIPConflict):
+		status = http.StatusConflict
+	}
+	writeJSON(w, status, apiError{Error: err.Error()})
+}
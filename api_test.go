@@ -0,0 +1,148 @@
+package vpnc
+import (
+	"testing"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strings"
+)
+
+func getTestAPI(t *testing.T, dir string) *APIServer {
+	ovpn := getFakeOVPNServer(t, dir)
+	ovpn.PersistIPFile = path.Join(dir, "ipp.txt")
+	if err := ioutil.WriteFile(ovpn.PersistIPFile, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	index := "V\t20500101000000Z\t\t01\tunknown\t/C=RU/CN=test.local\n" +
+		"V\t20500101000000Z\t\t02\tunknown\t/C=RU/CN=ivan\n" +
+		"R\t20500101000000Z\t160505000000Z\t03\tunknown\t/C=RU/CN=petr\n"
+	if err := ioutil.WriteFile(path.Join(dir, "index.txt"), []byte(index), 0644); err != nil {
+		t.Fatal(err)
+	}
+	rsa := getInstance()
+	rsa.KeyDir = dir
+	return &APIServer{Server: ovpn, RSA: rsa, Tokens: []string{"secret"}}
+}
+
+func apiRequest(api *APIServer, method, url, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, url, strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer secret")
+	rec := httptest.NewRecorder()
+	api.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestAPIAuth(t *testing.T) {
+	dir, err := ioutil.TempDir("", "api")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	api := getTestAPI(t, dir)
+	for _, token := range []string{"", "wrong"} {
+		req := httptest.NewRequest("GET", "/clients", nil)
+		req.Header.Set("X-API-Token", token)
+		rec := httptest.NewRecorder()
+		api.ServeHTTP(rec, req)
+		if rec.Code != http.StatusUnauthorized {
+			t.Error("Request with token", token, "must be denied", rec.Code)
+		}
+		var apiErr apiError
+		if err := json.Unmarshal(rec.Body.Bytes(), &apiErr); err != nil || apiErr.Error == "" {
+			t.Error("Error must be JSON", rec.Body.String())
+		}
+	}
+}
+
+func TestAPIClients(t *testing.T) {
+	dir, err := ioutil.TempDir("", "api")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	api := getTestAPI(t, dir)
+
+	rec := apiRequest(api, "GET", "/clients", "")
+	var clients []APIClient
+	if err = json.Unmarshal(rec.Body.Bytes(), &clients); err != nil || rec.Code != http.StatusOK {
+		t.Fatal("List clients", rec.Code, rec.Body.String())
+	}
+	if len(clients) != 2 || clients[0].Name != "ivan" || clients[0].Status != "valid" || clients[1].Status != "revoked" {
+		t.Error("Bad clients list", clients)
+	}
+
+	if rec = apiRequest(api, "PUT", "/clients/ivan/ip", `{"ip": "10.8.0.10"}`); rec.Code != http.StatusOK {
+		t.Fatal("Set static IP", rec.Code, rec.Body.String())
+	}
+	var client APIClient
+	rec = apiRequest(api, "GET", "/clients/ivan", "")
+	if err = json.Unmarshal(rec.Body.Bytes(), &client); err != nil || client.StaticIP != "10.8.0.10" {
+		t.Error("Static IP not set", rec.Body.String())
+	}
+	if rec = apiRequest(api, "DELETE", "/clients/ivan/ip", ""); rec.Code != http.StatusNoContent {
+		t.Fatal("Remove static IP", rec.Code, rec.Body.String())
+	}
+	if ips, _ := api.Server.ListStaticIP(); len(ips) != 0 {
+		t.Error("Static IP not removed", ips)
+	}
+
+	index, _ := ioutil.ReadFile(api.RSA.IndexFile())
+	if rec = apiRequest(api, "DELETE", "/clients/petr", ""); rec.Code != http.StatusGone {
+		t.Error("Revoked client must not be revoked again", rec.Code, rec.Body.String())
+	}
+	if data, _ := ioutil.ReadFile(api.RSA.IndexFile()); string(data) != string(index) {
+		t.Error("Index changed by repeated revoke", string(data))
+	}
+	if rec = apiRequest(api, "GET", "/clients/nobody", ""); rec.Code != http.StatusNotFound {
+		t.Error("Unknown client must not be found", rec.Code)
+	}
+	if rec = apiRequest(api, "POST", "/clients", `{"name": "ivan"}`); rec.Code != http.StatusConflict {
+		t.Error("Existing client must not be created", rec.Code)
+	}
+	if rec = apiRequest(api, "POST", "/clients", `{"name": "../ivan"}`); rec.Code != http.StatusBadRequest {
+		t.Error("Unsafe client name must be rejected", rec.Code)
+	}
+	for _, name := range []string{"ca", api.RSA.Server} {
+		if rec = apiRequest(api, "POST", "/clients", `{"name": "` + name + `"}`); rec.Code != http.StatusBadRequest {
+			t.Error("Reserved client name must be rejected", name, rec.Code)
+		}
+	}
+	if rec = apiRequest(api, "POST", "/clients", `{"name":`); rec.Code != http.StatusBadRequest {
+		t.Error("Bad request must be rejected", rec.Code)
+	}
+	if rec = apiRequest(api, "PATCH", "/clients", ""); rec.Code != http.StatusMethodNotAllowed {
+		t.Error("Unknown method must be rejected", rec.Code)
+	}
+}
+
+func TestAPIBundle(t *testing.T) {
+	dir, err := ioutil.TempDir("", "api")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	api := getTestAPI(t, dir)
+
+	rec := apiRequest(api, "GET", "/clients/ivan/bundle?format=ovpn&platform=windows", "")
+	if rec.Code != http.StatusOK {
+		t.Fatal("Download bundle", rec.Code, rec.Body.String())
+	}
+	if !strings.Contains(rec.Body.String(), "<ca>") || !strings.Contains(rec.Body.String(), "block-outside-dns") {
+		t.Error("Bad inline bundle", rec.Body.String())
+	}
+	if !strings.Contains(rec.Header().Get("Content-Disposition"), "ivan.ovpn") {
+		t.Error("Bad content disposition", rec.Header().Get("Content-Disposition"))
+	}
+	if rec = apiRequest(api, "GET", "/clients/ivan/bundle", ""); rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "application/zip" {
+		t.Error("Download default bundle", rec.Code, rec.Header())
+	}
+	if rec = apiRequest(api, "GET", "/clients/ivan/bundle?format=rar", ""); rec.Code != http.StatusBadRequest {
+		t.Error("Unknown format must be rejected", rec.Code)
+	}
+	if rec = apiRequest(api, "GET", "/clients/petr/bundle", ""); rec.Code != http.StatusGone {
+		t.Error("Bundle of revoked client must not be available", rec.Code)
+	}
+}
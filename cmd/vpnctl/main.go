@@ -0,0 +1,456 @@
+// Command vpnctl is a command-line tool for everyday administration of OpenVPN server built by vpn-control.
+//
+//   vpnctl [-config file] init [-force]
+//   vpnctl [-config file] client add|list|revoke|bundle [options] [name]
+//   vpnctl [-config file] ip set|list|rm [name] [ip]
+//   vpnctl [-config file] config show|lint
+//   vpnctl [-config file] apply [-dry-run] <state.json>
+//
+// Defaults are read from JSON config file (-config flag, VPNCTL_CONFIG or ~/.vpnctl.json)
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"text/tabwriter"
+	"time"
+
+	vpnc "github.com/reddec/vpn-control"
+)
+
+// Defaults of vpnctl
+type Config struct {
+	Server     string   `json:"server"`      // Server name (CN of server certificate)
+	Dir        string   `json:"dir"`         // Directory of server configuration and keys
+	ServerConf string   `json:"server_conf"` // Location of server.conf. Optional (<dir>/server.conf)
+	EasyRSA    string   `json:"easy_rsa"`    // Home of easy-rsa tools. Optional (/usr/share/easy-rsa)
+	Addresses  []string `json:"addresses"`   // Public addresses of server for client configurations
+	Format     string   `json:"format"`      // Default bundle format: zip, tar.gz or ovpn. Optional (zip)
+	Platform   string   `json:"platform"`    // Default client platform. Optional (legacy client.conf)
+
+	Remotes           []vpnc.Remote `json:"remotes"`             // Additional remotes (host, port, proto) of client configurations. Optional
+	RemoteRandom      bool          `json:"remote_random"`       // Clients pick random remote. Optional
+	ConnectRetry      int           `json:"connect_retry"`       // Seconds between client connection attempts. Optional
+	ConnectRetryMax   int           `json:"connect_retry_max"`   // Max seconds between attempts (backoff limit). Optional
+	ServerPollTimeout int           `json:"server_poll_timeout"` // Seconds to wait for server before next remote. Optional
+}
+
+// Location of server configuration
+func (cfg Config) ServerConfFile() string {
+	if cfg.ServerConf != "" {
+		return cfg.ServerConf
+	}
+	return path.Join(cfg.Dir, "server.conf")
+}
+
+// Easy-rsa with defaults for server
+func (cfg Config) RSA() vpnc.EasyRSA {
+	rsa := vpnc.DefaultEasyRSA(cfg.Server, cfg.Dir)
+	rsa.BinDir = cfg.EasyRSA
+	return rsa
+}
+
+// Read server configuration
+func (cfg Config) OpenVPN() (vpnc.OpenVPNServer, error) {
+	ovpn, err := vpnc.OpenServerConf(cfg.ServerConfFile())
+	if err != nil {
+		return ovpn, err
+	}
+	ovpn.Addresses = cfg.Addresses
+	ovpn.Remotes = cfg.Remotes
+	ovpn.RemoteRandom = cfg.RemoteRandom
+	ovpn.ConnectRetry = cfg.ConnectRetry
+	ovpn.ConnectRetryMax = cfg.ConnectRetryMax
+	ovpn.ServerPollTimeout = cfg.ServerPollTimeout
+	return ovpn, nil
+}
+
+// Read JSON config file. Missing file is not an error if it is default location
+func LoadConfig(file string, required bool) (Config, error) {
+	cfg := Config{Dir: "/etc/openvpn"}
+	data, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) && !required {
+		return cfg, nil
+	}
+	if err != nil {
+		return cfg, err
+	}
+	if err = json.Unmarshal(data, &cfg); err != nil {
+		return cfg, errors.New(file + ": " + err.Error())
+	}
+	return cfg, nil
+}
+
+func defaultConfigFile() string {
+	if file := os.Getenv("VPNCTL_CONFIG"); file != "" {
+		return file
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ".vpnctl.json"
+	}
+	return filepath.Join(home, ".vpnctl.json")
+}
+
+const usage = `Usage: vpnctl [-config file] <command> [options]
+
+Commands:
+  init [-force]                     build missing PKI and server configuration (-force destroys existing PKI)
+  client add [-format f] [-platform p] [-o file] [-owner o] [-email e] [-team t] [-notes n] [-reissue] <name>
+                                    generate client keys and write bundle
+  client list [-owner o] [-team t]  list clients
+  client revoke <name>              revoke client certificate and remove static IP
+  client bundle [-format f] [-platform p] [-o file] <name>
+                                    write bundle for existing client
+  ip set <name> <ip> [ipv6]         set static IP of client
+  ip list                           list static IPs
+  ip rm <name>                      remove static IP of client
+  config show                       show parsed server configuration
+  config lint                       validate server configuration
+  apply [-dry-run] <state.json>     reconcile server with desired state
+`
+
+func main() {
+	if err := run(os.Args[1:], os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, "vpnctl:", err)
+		os.Exit(1)
+	}
+}
+
+func run(args []string, out io.Writer) error {
+	flags := flag.NewFlagSet("vpnctl", flag.ContinueOnError)
+	flags.Usage = func() { fmt.Fprint(flags.Output(), usage) }
+	configFile := flags.String("config", "", "config file (default VPNCTL_CONFIG or ~/.vpnctl.json)")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	file, required := *configFile, true
+	if file == "" {
+		file, required = defaultConfigFile(), false
+	}
+	cfg, err := LoadConfig(file, required)
+	if err != nil {
+		return err
+	}
+	args = flags.Args()
+	if len(args) == 0 {
+		flags.Usage()
+		return errors.New("command is required")
+	}
+	cmd := &command{cfg: cfg, out: out}
+	switch args[0] {
+	case "init":
+		return cmd.init(args[1:])
+	case "client":
+		return cmd.client(args[1:])
+	case "ip":
+		return cmd.ip(args[1:])
+	case "config":
+		return cmd.config(args[1:])
+	case "apply":
+		return cmd.apply(args[1:])
+	}
+	flags.Usage()
+	return errors.New("unknown command " + args[0])
+}
+
+type command struct {
+	cfg Config
+	out io.Writer
+}
+
+func (cmd *command) init(args []string) error {
+	flags := flag.NewFlagSet("init", flag.ContinueOnError)
+	force := flags.Bool("force", false, "destroy existing PKI and configuration and build new")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if cmd.cfg.Server == "" {
+		return errors.New("server name is not set in config")
+	}
+	_, ovpn, err := vpnc.EnsureSimpleDebian(cmd.cfg.Server, cmd.cfg.Dir, *force)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(cmd.out, "Server configuration is ready in", cmd.cfg.Dir, "on port", ovpn.Port, ovpn.Protocol)
+	return nil
+}
+
+func (cmd *command) client(args []string) error {
+	if len(args) == 0 {
+		return errors.New("client subcommand is required: add, list, revoke or bundle")
+	}
+	switch args[0] {
+	case "list":
+		return cmd.clientList(args[1:])
+	case "revoke":
+		if len(args) != 2 {
+			return errors.New("usage: client revoke <name>")
+		}
+		return cmd.clientRevoke(args[1])
+	case "add", "bundle":
+		return cmd.clientBundle(args[0], args[1:])
+	}
+	return errors.New("unknown client subcommand " + args[0])
+}
+
+func (cmd *command) clientList(args []string) error {
+	flags := flag.NewFlagSet("client list", flag.ContinueOnError)
+	owner := flags.String("owner", "", "show only clients of owner")
+	team := flags.String("team", "", "show only clients of team")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	ovpn, err := cmd.cfg.OpenVPN()
+	if err != nil {
+		return err
+	}
+	clients, err := vpnc.ListClients(ovpn, cmd.cfg.RSA())
+	if err != nil {
+		return err
+	}
+	tw := tabwriter.NewWriter(cmd.out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tSTATUS\tEXPIRES\tSTATIC IP\tOWNER\tTEAM")
+	for _, c := range clients {
+		if (*owner != "" && c.Owner != *owner) || (*team != "" && c.Team != *team) {
+			continue
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", c.Name, c.Status, c.Expires.Format(time.RFC3339), c.StaticIP, c.Owner, c.Team)
+	}
+	return tw.Flush()
+}
+
+func (cmd *command) clientRevoke(name string) error {
+	ovpn, err := cmd.cfg.OpenVPN()
+	if err != nil {
+		return err
+	}
+	if err = cmd.cfg.RSA().RevokeClient(name); err != nil {
+		return err
+	}
+	if ovpn.PersistIPFile != "" {
+		if err = ovpn.RemoveStaticIP(name); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	fmt.Fprintln(cmd.out, "Client", name, "revoked")
+	return nil
+}
+
+func (cmd *command) clientBundle(sub string, args []string) error {
+	flags := flag.NewFlagSet("client " + sub, flag.ContinueOnError)
+	formatName := flags.String("format", cmd.cfg.Format, "bundle format: zip, tar.gz or ovpn")
+	platformName := flags.String("platform", cmd.cfg.Platform, "client platform: linux, linux-systemd, windows, macos, android, ios")
+	output := flags.String("o", "", "output file (default <name>.<format> in current directory, - for stdout)")
+	var meta vpnc.ClientRecord
+	reissue := false
+	if sub == "add" {
+		flags.BoolVar(&reissue, "reissue", false, "revoke existing certificate of client and issue new")
+		flags.StringVar(&meta.Owner, "owner", "", "owner of client")
+		flags.StringVar(&meta.Email, "email", "", "email of owner")
+		flags.StringVar(&meta.Team, "team", "", "team of owner")
+		flags.StringVar(&meta.Notes, "notes", "", "notes")
+	}
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return errors.New("usage: client " + sub + " [options] <name>")
+	}
+	name := flags.Arg(0)
+	// name is used in file paths
+	if err := vpnc.ValidateClientName(name); err != nil {
+		return err
+	}
+	format, err := vpnc.ParseArchiveFormat(*formatName)
+	if err != nil {
+		return err
+	}
+	platform, err := vpnc.ParsePlatform(*platformName)
+	if err != nil {
+		return err
+	}
+	ovpn, err := cmd.cfg.OpenVPN()
+	if err != nil {
+		return err
+	}
+	ovpn.ClientPlatform = platform
+	target := *output
+	if target == "" {
+		target = name + format.Extension()
+	}
+	write := func(keys vpnc.ClientKeyFiles) error {
+		if target == "-" {
+			return vpnc.WriteClientBundle(cmd.out, format, ovpn, keys)
+		}
+		return writeBundle(target, format, ovpn, keys)
+	}
+	rsa := cmd.cfg.RSA()
+	if reissue {
+		rsa.Reissue = vpnc.ReissueRevoke
+	}
+	if sub == "add" {
+		// keys are discarded if bundle could not be written
+		_, err = rsa.ProvisionClient(name, func(keys vpnc.ClientKeyFiles) error {
+			err := rsa.Registry().Update(name, func(rec *vpnc.ClientRecord) {
+				rec.Owner, rec.Email, rec.Team, rec.Notes = meta.Owner, meta.Email, meta.Team, meta.Notes
+			})
+			if err != nil {
+				return err
+			}
+			return write(keys)
+		})
+	} else if err = requireValidClient(ovpn, rsa, name); err == nil {
+		err = write(rsa.ClientKeys(name))
+	}
+	if err != nil || target == "-" {
+		return err
+	}
+	fmt.Fprintln(cmd.out, "Bundle for", name, "written to", target)
+	return nil
+}
+
+// Client has valid (not revoked or expired) certificate in index
+func requireValidClient(ovpn vpnc.OpenVPNServer, rsa vpnc.EasyRSA, name string) error {
+	clients, err := vpnc.ListClients(ovpn, rsa)
+	if err != nil {
+		return err
+	}
+	for _, c := range clients {
+		if c.Name != name {
+			continue
+		}
+		if c.Status != "valid" {
+			return errors.New("client " + name + " is " + c.Status)
+		}
+		return nil
+	}
+	return errors.New("client " + name + " not found")
+}
+
+func writeBundle(target string, format vpnc.ArchiveFormat, ovpn vpnc.OpenVPNServer, keys vpnc.ClientKeyFiles) error {
+	f, err := os.OpenFile(target, os.O_CREATE | os.O_TRUNC | os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	if err = vpnc.WriteClientBundle(f, format, ovpn, keys); err != nil {
+		f.Close()
+		os.Remove(target)
+		return err
+	}
+	return f.Close()
+}
+
+func (cmd *command) ip(args []string) error {
+	if len(args) == 0 {
+		return errors.New("ip subcommand is required: set, list or rm")
+	}
+	ovpn, err := cmd.cfg.OpenVPN()
+	if err != nil {
+		return err
+	}
+	if ovpn.PersistIPFile == "" {
+		return errors.New("ifconfig-pool-persist is not configured in " + cmd.cfg.ServerConfFile())
+	}
+	switch args[0] {
+	case "list":
+		entries, err := ovpn.StaticIPs()
+		if err != nil {
+			return err
+		}
+		tw := tabwriter.NewWriter(cmd.out, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "NAME\tIP\tIPV6")
+		for _, entry := range entries {
+			fmt.Fprintf(tw, "%s\t%s\t%s\n", entry.Client, entry.IPv4, entry.IPv6)
+		}
+		return tw.Flush()
+	case "set":
+		if len(args) != 3 && len(args) != 4 {
+			return errors.New("usage: ip set <name> <ip> [ipv6]")
+		}
+		entry := vpnc.StaticIP{Client: args[1], IPv4: args[2]}
+		if len(args) == 4 {
+			entry.IPv6 = args[3]
+		}
+		return ovpn.SetStaticIP(entry)
+	case "rm":
+		if len(args) != 2 {
+			return errors.New("usage: ip rm <name>")
+		}
+		return ovpn.RemoveStaticIP(args[1])
+	}
+	return errors.New("unknown ip subcommand " + args[0])
+}
+
+func (cmd *command) config(args []string) error {
+	if len(args) != 1 {
+		return errors.New("config subcommand is required: show or lint")
+	}
+	switch args[0] {
+	case "show":
+		ovpn, err := cmd.cfg.OpenVPN()
+		if err != nil {
+			return err
+		}
+		data, err := json.MarshalIndent(struct {
+			LocalAddr      string
+			Addresses      []string
+			Port           uint16
+			Protocol       string
+			Keys           vpnc.KeyFiles
+			PersistIPFile  string
+			TlsKey         string
+			TlsCrypt       bool
+			ClientToClient bool
+			StatusFile     string
+			ManagementAddr string
+		}{ovpn.LocalAddr, ovpn.Addresses, ovpn.Port, ovpn.Protocol, ovpn.Keys, ovpn.PersistIPFile, ovpn.TlsKey,
+			ovpn.TlsCrypt, ovpn.ClientToClient, ovpn.StatusPath(), ovpn.ManagementAddr}, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(cmd.out, string(data))
+		return err
+	case "lint":
+		findings, err := vpnc.LintServerConf(cmd.cfg.ServerConfFile())
+		if err != nil {
+			return err
+		}
+		for _, f := range findings {
+			fmt.Fprintln(cmd.out, f)
+		}
+		if vpnc.HasErrors(findings) {
+			return errors.New("configuration has errors")
+		}
+		if len(findings) == 0 {
+			fmt.Fprintln(cmd.out, "OK")
+		}
+		return nil
+	}
+	return errors.New("unknown config subcommand " + args[0])
+}
+
+func (cmd *command) apply(args []string) error {
+	flags := flag.NewFlagSet("apply", flag.ContinueOnError)
+	dryRun := flags.Bool("dry-run", false, "only show planned changes")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return errors.New("usage: apply [-dry-run] <state.json>")
+	}
+	state, err := vpnc.LoadDesiredState(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	plan, err := vpnc.ApplyState(state, cmd.cfg.ServerConfFile(), cmd.cfg.RSA(), *dryRun)
+	fmt.Fprint(cmd.out, plan)
+	return err
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+func prepareTestConfig(t *testing.T) (string, string) {
+	dir, err := ioutil.TempDir("", "vpnctl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	serverConf := "port 1194\nproto udp\nserver 10.8.0.0 255.255.255.0\nca ca.crt\ncert server.crt\nkey server.key\ndh dh.pem\n" +
+		"ifconfig-pool-persist " + path.Join(dir, "ipp.txt") + "\n"
+	if err = ioutil.WriteFile(path.Join(dir, "server.conf"), []byte(serverConf), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err = ioutil.WriteFile(path.Join(dir, "ipp.txt"), nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	config := `{"server": "test.local", "dir": "` + dir + `", "addresses": ["127.0.0.1"]}`
+	if err = ioutil.WriteFile(path.Join(dir, "vpnctl.json"), []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return dir, path.Join(dir, "vpnctl.json")
+}
+
+func TestStaticIPCommands(t *testing.T) {
+	dir, config := prepareTestConfig(t)
+	defer os.RemoveAll(dir)
+	out := &bytes.Buffer{}
+	if err := run([]string{"-config", config, "ip", "set", "ivan", "10.8.0.10"}, out); err != nil {
+		t.Fatal("Set static IP", err)
+	}
+	if err := run([]string{"-config", config, "ip", "list"}, out); err != nil {
+		t.Fatal("List static IPs", err)
+	}
+	if !strings.Contains(out.String(), "ivan") || !strings.Contains(out.String(), "10.8.0.10") {
+		t.Error("Static IP not listed", out.String())
+	}
+	if err := run([]string{"-config", config, "ip", "rm", "ivan"}, out); err != nil {
+		t.Fatal("Remove static IP", err)
+	}
+	data, _ := ioutil.ReadFile(path.Join(dir, "ipp.txt"))
+	if strings.Contains(string(data), "ivan") {
+		t.Error("Static IP not removed")
+	}
+}
+
+func TestConfigCommands(t *testing.T) {
+	dir, config := prepareTestConfig(t)
+	defer os.RemoveAll(dir)
+	out := &bytes.Buffer{}
+	if err := run([]string{"-config", config, "config", "show"}, out); err != nil {
+		t.Fatal("Show config", err)
+	}
+	if !strings.Contains(out.String(), `"Port": 1194`) {
+		t.Error("Bad config output", out.String())
+	}
+	out.Reset()
+	if err := run([]string{"-config", config, "config", "lint"}, out); err == nil {
+		t.Error("Lint must fail for missing keys")
+	}
+	if !strings.Contains(out.String(), "key-missing") {
+		t.Error("Missing keys not reported", out.String())
+	}
+}
+
+func TestBadCommands(t *testing.T) {
+	dir, config := prepareTestConfig(t)
+	defer os.RemoveAll(dir)
+	for _, args := range [][]string{{}, {"unknown"}, {"client"}, {"client", "revoke"}, {"ip", "set", "ivan"}, {"config"}} {
+		if err := run(append([]string{"-config", config}, args...), ioutil.Discard); err == nil {
+			t.Error("Command must fail", args)
+		}
+	}
+	if err := run([]string{"-config", path.Join(dir, "missing.json"), "client", "list"}, ioutil.Discard); err == nil {
+		t.Error("Missing explicit config must fail")
+	}
+}
+
+func TestApplyCommand(t *testing.T) {
+	dir, config := prepareTestConfig(t)
+	defer os.RemoveAll(dir)
+	state := path.Join(dir, "state.json")
+	if err := ioutil.WriteFile(state, []byte(`{"server": {"port": 443}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	out := &bytes.Buffer{}
+	if err := run([]string{"-config", config, "apply", "-dry-run", state}, out); err != nil {
+		t.Fatal("Plan state", err)
+	}
+	if !strings.Contains(out.String(), "update-config: port 443") {
+		t.Error("Bad plan", out.String())
+	}
+	if err := run([]string{"-config", config, "apply", state}, ioutil.Discard); err != nil {
+		t.Fatal("Apply state", err)
+	}
+	data, _ := ioutil.ReadFile(path.Join(dir, "server.conf"))
+	if !strings.HasPrefix(string(data), "port 443\n") {
+		t.Error("State not applied", string(data))
+	}
+}
+
+func TestBundleRequiresValidClient(t *testing.T) {
+	dir, config := prepareTestConfig(t)
+	defer os.RemoveAll(dir)
+	index := "R\t20500101000000Z\t200101000000Z\t02\tunknown\t/CN=petr\n"
+	if err := ioutil.WriteFile(path.Join(dir, "index.txt"), []byte(index), 0644); err != nil {
+		t.Fatal(err)
+	}
+	target := path.Join(dir, "bundle.zip")
+	for _, name := range []string{"petr", "anna", "../anna"} {
+		if err := run([]string{"-config", config, "client", "bundle", "-o", target, name}, ioutil.Discard); err == nil {
+			t.Error("Bundle must not be written for", name)
+		}
+		if _, err := os.Stat(target); !os.IsNotExist(err) {
+			t.Error("Bundle file created for", name)
+		}
+	}
+}
+
+func TestConfigClientOptions(t *testing.T) {
+	dir, _ := prepareTestConfig(t)
+	defer os.RemoveAll(dir)
+	var cfg Config
+	data := `{"server": "test.local", "dir": "` + dir + `", "connect_retry": 5, "connect_retry_max": 60, "server_poll_timeout": 10}`
+	if err := json.Unmarshal([]byte(data), &cfg); err != nil {
+		t.Fatal(err)
+	}
+	ovpn, err := cfg.OpenVPN()
+	if err != nil {
+		t.Fatal("Open server", err)
+	}
+	if ovpn.ConnectRetry != 5 || ovpn.ConnectRetryMax != 60 || ovpn.ServerPollTimeout != 10 {
+		t.Error("Client options not applied", ovpn.ConnectRetry, ovpn.ConnectRetryMax, ovpn.ServerPollTimeout)
+	}
+}
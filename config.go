@@ -0,0 +1,317 @@
+package vpnc
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Marker used instead of file location for keys embedded as inline blocks (<ca>...</ca>)
+const InlineFile = "[inline]"
+
+// Kind of configuration node
+type NodeKind int
+
+const (
+	NodeBlank     NodeKind = iota // Empty line
+	NodeComment                   // Comment line started by # or ;
+	NodeDirective                 // Directive with arguments: proto udp
+	NodeInline                    // Inline block: <ca>...</ca>
+)
+
+// Single node of OpenVPN configuration. Unchanged nodes are written back exactly as they were read
+type Node struct {
+	Kind    NodeKind
+	Name    string   // Name of directive or inline block
+	Args    []string // Unquoted arguments of directive
+	Body    string   // Content of inline block or text of comment (with leading # or ;)
+	Comment string   // Trailing comment of directive (with leading # or ;). Optional
+
+	raw  string // Original text including line endings
+	orig string // Rendered original state to detect changes
+}
+
+// Parsed OpenVPN configuration: ordered list of directives, comments, blank lines and inline blocks
+type Config struct {
+	Nodes []*Node
+}
+
+// Parse OpenVPN configuration
+func ParseConfig(r io.Reader) (*Config, error) {
+	reader := bufio.NewReader(r)
+	cfg := &Config{}
+	var inline *Node
+	var body bytes.Buffer
+	lineNum := 0
+	for {
+		line, err := reader.ReadString('\n')
+		if line == "" && err != nil {
+			if err != io.EOF {
+				return nil, err
+			}
+			break
+		}
+		lineNum++
+		text := strings.TrimRight(line, "\r\n")
+		trimmed := strings.TrimSpace(text)
+		if inline != nil {
+			inline.raw += line
+			if trimmed == "</" + inline.Name + ">" {
+				inline.Body = body.String()
+				inline.orig = inline.render()
+				cfg.Nodes = append(cfg.Nodes, inline)
+				inline = nil
+				body.Reset()
+			} else {
+				body.WriteString(text + "\n")
+			}
+			continue
+		}
+		node := &Node{raw: line}
+		switch {
+		case trimmed == "":
+			node.Kind = NodeBlank
+		case trimmed[0] == '#' || trimmed[0] == ';':
+			node.Kind = NodeComment
+			node.Body = trimmed
+		case strings.HasPrefix(trimmed, "<") && strings.HasSuffix(trimmed, ">") && !strings.HasPrefix(trimmed, "</"):
+			node.Kind = NodeInline
+			node.Name = trimmed[1:len(trimmed) - 1]
+			inline = node
+			continue
+		default:
+			node.Kind = NodeDirective
+			tokens, comment, err := splitDirective(trimmed)
+			if err == nil && len(tokens) == 0 {
+				err = errors.New("empty directive")
+			}
+			if err != nil {
+				return nil, errors.New("Line " + strconv.Itoa(lineNum) + ": " + err.Error())
+			}
+			node.Name = tokens[0]
+			node.Args = tokens[1:]
+			node.Comment = comment
+		}
+		node.orig = node.render()
+		cfg.Nodes = append(cfg.Nodes, node)
+	}
+	if inline != nil {
+		return nil, errors.New("Inline block <" + inline.Name + "> is not closed")
+	}
+	return cfg, nil
+}
+
+// Read and parse OpenVPN configuration file
+func ReadConfig(file string) (*Config, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ParseConfig(f)
+}
+
+// Write configuration. Unchanged nodes are written as they were read, changed and new nodes are rendered
+func (cfg *Config) WriteTo(w io.Writer) (int64, error) {
+	var total int64
+	for _, node := range cfg.Nodes {
+		n, err := io.WriteString(w, node.String())
+		total += int64(n)
+		if err != nil {
+			return total, err
+		}
+	}
+	return total, nil
+}
+
+// Atomically write configuration to file. Mode and owner of existing file are preserved
+func (cfg *Config) Save(file string) error {
+	buf := &bytes.Buffer{}
+	if _, err := cfg.WriteTo(buf); err != nil {
+		return err
+	}
+	return writeFileAtomic(file, buf.Bytes(), 0644)
+}
+
+// Configuration as text
+func (cfg *Config) String() string {
+	buf := &bytes.Buffer{}
+	cfg.WriteTo(buf)
+	return buf.String()
+}
+
+// First directive or inline block with specified name or nil
+func (cfg *Config) Get(name string) *Node {
+	for _, node := range cfg.Nodes {
+		if node.Name == name && (node.Kind == NodeDirective || node.Kind == NodeInline) {
+			return node
+		}
+	}
+	return nil
+}
+
+// All directives and inline blocks with specified name
+func (cfg *Config) GetAll(name string) []*Node {
+	var nodes []*Node
+	for _, node := range cfg.Nodes {
+		if node.Name == name && (node.Kind == NodeDirective || node.Kind == NodeInline) {
+			nodes = append(nodes, node)
+		}
+	}
+	return nodes
+}
+
+// Check that directive or inline block is present
+func (cfg *Config) Has(name string) bool {
+	return cfg.Get(name) != nil
+}
+
+// First argument of directive or empty string
+func (cfg *Config) Value(name string) string {
+	node := cfg.Get(name)
+	if node == nil || len(node.Args) == 0 {
+		return ""
+	}
+	return node.Args[0]
+}
+
+// Set arguments of first directive with specified name or append new directive
+func (cfg *Config) Set(name string, args ...string) *Node {
+	node := cfg.Get(name)
+	if node == nil || node.Kind != NodeDirective {
+		return cfg.Add(name, args...)
+	}
+	node.Args = args
+	return node
+}
+
+// Append new directive
+func (cfg *Config) Add(name string, args ...string) *Node {
+	node := &Node{Kind: NodeDirective, Name: name, Args: args}
+	cfg.Nodes = append(cfg.Nodes, node)
+	return node
+}
+
+// Set content of first inline block with specified name or append new block
+func (cfg *Config) SetInline(name, body string) *Node {
+	if body != "" && !strings.HasSuffix(body, "\n") {
+		body += "\n"
+	}
+	for _, node := range cfg.GetAll(name) {
+		if node.Kind == NodeInline {
+			node.Body = body
+			return node
+		}
+	}
+	node := &Node{Kind: NodeInline, Name: name, Body: body}
+	cfg.Nodes = append(cfg.Nodes, node)
+	return node
+}
+
+// Remove all directives and inline blocks with specified name. Returns number of removed nodes
+func (cfg *Config) Remove(name string) int {
+	var nodes []*Node
+	for _, node := range cfg.Nodes {
+		if node.Name == name && (node.Kind == NodeDirective || node.Kind == NodeInline) {
+			continue
+		}
+		nodes = append(nodes, node)
+	}
+	removed := len(cfg.Nodes) - len(nodes)
+	cfg.Nodes = nodes
+	return removed
+}
+
+// Text of node: original text if node was not changed or rendered otherwise
+func (node *Node) String() string {
+	if node.raw != "" && node.render() == node.orig {
+		return node.raw
+	}
+	eol := "\n"
+	if strings.HasSuffix(node.raw, "\r\n") {
+		eol = "\r\n"
+	}
+	text := node.render()
+	if node.Kind == NodeInline {
+		return strings.Replace(text, "\n", eol, -1)
+	}
+	return text + eol
+}
+
+// Render node without original formatting
+func (node *Node) render() string {
+	switch node.Kind {
+	case NodeComment:
+		return node.Body
+	case NodeDirective:
+		parts := []string{quoteArg(node.Name)}
+		for _, arg := range node.Args {
+			parts = append(parts, quoteArg(arg))
+		}
+		if node.Comment != "" {
+			parts = append(parts, node.Comment)
+		}
+		return strings.Join(parts, " ")
+	case NodeInline:
+		return "<" + node.Name + ">\n" + node.Body + "</" + node.Name + ">\n"
+	}
+	return ""
+}
+
+// Split directive line to tokens (like OpenVPN does): whitespace separated, quoted by " or ', escaped by \.
+// Token started by # or ; begins trailing comment
+func splitDirective(line string) ([]string, string, error) {
+	var tokens []string
+	var token bytes.Buffer
+	inToken := false
+	var quote rune
+	escaped := false
+	for i, c := range line {
+		switch {
+		case escaped:
+			token.WriteRune(c)
+			escaped = false
+		case c == '\\' && quote != '\'':
+			escaped = true
+			inToken = true
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			} else {
+				token.WriteRune(c)
+			}
+		case c == '"' || c == '\'':
+			quote = c
+			inToken = true
+		case c == ' ' || c == '\t':
+			if inToken {
+				tokens = append(tokens, token.String())
+				token.Reset()
+				inToken = false
+			}
+		case (c == '#' || c == ';') && !inToken:
+			return tokens, strings.TrimSpace(line[i:]), nil
+		default:
+			token.WriteRune(c)
+			inToken = true
+		}
+	}
+	if quote != 0 {
+		return nil, "", errors.New("unterminated quote")
+	}
+	if inToken {
+		tokens = append(tokens, token.String())
+	}
+	return tokens, "", nil
+}
+
+// Quote argument if it contains spaces, quotes or special characters
+func quoteArg(arg string) string {
+	if arg != "" && !strings.ContainsAny(arg, " \t\"'\\#;") {
+		return arg
+	}
+	return "\"" + strings.NewReplacer("\\", "\\\\", "\"", "\\\"").Replace(arg) + "\""
+}
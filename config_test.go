@@ -0,0 +1,106 @@
+package vpnc
+import (
+	"testing"
+	"strings"
+	"reflect"
+)
+
+const testHandConf = `# Hand-tuned server
+port	1194
+proto udp   # fast one
+;comp-lzo
+
+push "route 192.168.10.0 255.255.255.0"
+push "dhcp-option DNS 8.8.8.8"
+server 10.8.0.0 255.255.255.0
+<ca>
+-----BEGIN CERTIFICATE-----
+AAAA
+-----END CERTIFICATE-----
+</ca>
+cert "/etc/openvpn/my server.crt"
+key /etc/openvpn/server.key
+dh /etc/openvpn/dh2048.pem
+tls-auth /etc/openvpn/ta.key 0
+client-to-client
+`
+
+func TestConfigRoundTrip(t *testing.T) {
+	cfg, err := ParseConfig(strings.NewReader(testHandConf))
+	if err != nil {
+		t.Fatal("Parse config", err)
+	}
+	if cfg.String() != testHandConf {
+		t.Error("Unchanged config must be written as is:\n" + cfg.String())
+	}
+	cfgCRLF, err := ParseConfig(strings.NewReader(strings.Replace(testHandConf, "\n", "\r\n", -1)))
+	if err != nil {
+		t.Fatal("Parse CRLF config", err)
+	}
+	if cfgCRLF.String() != strings.Replace(testHandConf, "\n", "\r\n", -1) {
+		t.Error("Unchanged CRLF config must be written as is")
+	}
+}
+
+func TestConfigParse(t *testing.T) {
+	cfg, err := ParseConfig(strings.NewReader(testHandConf))
+	if err != nil {
+		t.Fatal("Parse config", err)
+	}
+	if cfg.Value("port") != "1194" {
+		t.Error("Tab separated port not parsed", cfg.Value("port"))
+	}
+	proto := cfg.Get("proto")
+	if proto == nil || !reflect.DeepEqual(proto.Args, []string{"udp"}) || proto.Comment != "# fast one" {
+		t.Error("Directive with trailing comment not parsed", proto)
+	}
+	pushes := cfg.GetAll("push")
+	if len(pushes) != 2 || pushes[0].Args[0] != "route 192.168.10.0 255.255.255.0" {
+		t.Error("Quoted arguments not parsed", pushes)
+	}
+	if cfg.Value("cert") != "/etc/openvpn/my server.crt" {
+		t.Error("Quoted path not parsed", cfg.Value("cert"))
+	}
+	ca := cfg.Get("ca")
+	if ca == nil || ca.Kind != NodeInline || !strings.Contains(ca.Body, "AAAA") {
+		t.Error("Inline CA not parsed", ca)
+	}
+	if cfg.Has("comp-lzo") {
+		t.Error("Commented directive must not be parsed")
+	}
+	server, err := ServerFromConfig(cfg)
+	if err != nil {
+		t.Fatal("Server from config", err)
+	}
+	if server.Port != 1194 || server.Protocol != "udp" || server.Keys.CA.Certificate != InlineFile ||
+		server.TlsKey != "/etc/openvpn/ta.key" || !server.ClientToClient {
+		t.Error("Bad server parameters", server)
+	}
+}
+
+func TestConfigEdit(t *testing.T) {
+	cfg, err := ParseConfig(strings.NewReader(testHandConf))
+	if err != nil {
+		t.Fatal("Parse config", err)
+	}
+	cfg.Set("port", "443")
+	cfg.Remove("push")
+	cfg.Add("push", "redirect-gateway def1")
+	cfg.SetInline("ca", "NEW")
+	expected := strings.Replace(testHandConf, "port\t1194\n", "port 443\n", 1)
+	expected = strings.Replace(expected, "push \"route 192.168.10.0 255.255.255.0\"\npush \"dhcp-option DNS 8.8.8.8\"\n", "", 1)
+	expected = strings.Replace(expected, "-----BEGIN CERTIFICATE-----\nAAAA\n-----END CERTIFICATE-----\n", "NEW\n", 1)
+	expected += "push \"redirect-gateway def1\"\n"
+	if cfg.String() != expected {
+		t.Error("Unexpected edited config:\n" + cfg.String())
+	}
+}
+
+func TestConfigBadInline(t *testing.T) {
+	if _, err := ParseConfig(strings.NewReader("<ca>\nAAA\n")); err == nil {
+		t.Error("Not closed inline block must fail")
+	}
+	if _, err := ParseConfig(strings.NewReader("push \"route\n")); err == nil {
+		t.Error("Unterminated quote must fail")
+	}
+}
@@ -7,6 +7,11 @@ import (
 	"os"
 	"regexp"
 	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"strings"
+	"time"
 )
 
 type EasyRSA struct {
@@ -23,6 +28,53 @@ type EasyRSA struct {
 	State        string
 	Organization string
 	Email        string
+	Reissue      ReissuePolicy // Behaviour of BuildClientKeys for client with valid certificate
+}
+
+// Behaviour of BuildClientKeys if client with valid certificate already exists
+type ReissuePolicy int
+
+const (
+	ReissueNever  ReissuePolicy = iota // Fail with ErrClientExists
+	ReissueRevoke                      // Revoke existing certificate and issue new one
+)
+
+var clientNamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._@-]{0,63}$`)
+
+// Check that client name could be used as common name and file name: up to 64 letters, digits, dots,
+// underscores, dashes and @, starting from letter or digit, without "..". Returns error wrapping ErrInvalidClientName
+func ValidateClientName(name string) error {
+	if !clientNamePattern.MatchString(name) || strings.Contains(name, "..") {
+		return fmt.Errorf("%w %q", ErrInvalidClientName, name)
+	}
+	return nil
+}
+
+// Names of easy-rsa files which client key files must not overwrite (compared case insensitive, * is any suffix)
+var reservedClientNames = []string{"ca", "ta", "dh*", "index*", "serial*", "crl*"}
+
+// Same as ValidateClientName but also rejects names reserved by PKI: reservedClientNames, server name and
+// names which key files (<name>.crt, <name>.key) would replace KeyFiles, CRLFile or TLS key
+func (er EasyRSA) CheckClientName(name string) error {
+	if err := ValidateClientName(name); err != nil {
+		return err
+	}
+	lower := strings.ToLower(name)
+	reserved := append([]string{strings.ToLower(er.Server)}, reservedClientNames...)
+	for _, r := range reserved {
+		if lower == r || (strings.HasSuffix(r, "*") && strings.HasPrefix(lower, strings.TrimSuffix(r, "*"))) {
+			return fmt.Errorf("%w %q: reserved by PKI", ErrInvalidClientName, name)
+		}
+	}
+	keys := er.KeyFiles()
+	client := er.ClientKeys(name)
+	for _, file := range []string{keys.CA.Certificate, keys.CA.Key, keys.Server.Certificate, keys.Server.Key,
+		keys.DiffieHellman, er.CRLFile(), path.Join(er.KeysDir(), "ta.key")} {
+		if strings.EqualFold(file, client.Files.Certificate) || strings.EqualFold(file, client.Files.Key) {
+			return fmt.Errorf("%w %q: key files replace %s", ErrInvalidClientName, name, file)
+		}
+	}
+	return nil
 }
 
 type KeyPair struct {
@@ -40,12 +92,28 @@ type ClientKeyFiles struct {
 	Files          KeyPair
 	Name           string // Client name
 	SigningRequest string // Certification sign request (optionally, for future use)
+	Serial         *big.Int // Serial number of certificate issued by BuildClientKeys (nil if nothing issued)
+}
+
+// Certificate record of easy-rsa (OpenSSL CA) database index.txt
+type IndexEntry struct {
+	Status     string    // V - valid, R - revoked, E - expired
+	Expires    time.Time // Certificate expiration
+	Revoked    time.Time // Revocation time. Zero for not revoked certificates
+	Serial     string    // Serial number in hex (keys dir contains <serial>.pem copy of certificate)
+	Subject    string    // Distinguished name like /C=RU/ST=CR/CN=ivan/emailAddress=vpn@vcontrol.com
+	CommonName string    // CN from subject
+}
+
+// Certificate is valid: not revoked and not expired
+func (ie IndexEntry) Valid() bool {
+	return ie.Status == "V" && time.Now().Before(ie.Expires)
 }
 
 func (er EasyRSA) whichOpenSLLCNF() (string, error) {
 	out, err := exec.Command("openssl", "version").Output()
 	if err != nil {
-		return "", err
+		return "", &ToolError{Tool: "openssl", Err: err}
 	}
 	if ok, _ := regexp.Match(".*?0\\.9\\.6.*", out); ok {
 		return path.Join(er.HomeDir(), "openssl-0.9.6.cnf"), nil
@@ -54,7 +122,7 @@ func (er EasyRSA) whichOpenSLLCNF() (string, error) {
 	}else if ok, _ := regexp.Match(".*?1\\.0.*", out); ok {
 		return path.Join(er.HomeDir(), "openssl-1.0.0.cnf"), nil
 	}
-	return "", errors.New("No cnf file could be found")
+	return "", fmt.Errorf("%w: %s", ErrUnsupportedOpenSSL, strings.TrimSpace(string(out)))
 }
 
 // Home directory of easy-rsa tools. Returns default Debian location if not present
@@ -123,15 +191,22 @@ func (er EasyRSA) getEnv() ([]string, error) {
 }
 
 func (er EasyRSA) runWithEnv(command string, args ...string) error {
+	return er.runWithExtraEnv(nil, command, args...)
+}
+
+func (er EasyRSA) runWithExtraEnv(extra []string, command string, args ...string) error {
 	env, err := er.getEnv()
 	if err != nil {
 		return err
 	}
 	cmd := exec.Command(command, args...)
-	cmd.Env = append(os.Environ(), env...)
+	cmd.Env = append(append(os.Environ(), env...), extra...)
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
-	return cmd.Run()
+	if err = cmd.Run(); err != nil {
+		return &ToolError{Tool: command, Err: err}
+	}
+	return nil
 }
 
 func (er EasyRSA) pkitool(args ...string) error {
@@ -140,6 +215,11 @@ func (er EasyRSA) pkitool(args ...string) error {
 
 // Removes all in keys directory and initialize again
 func (er EasyRSA) CleanAll() error {
+	unlock, err := er.Lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
 	return er.runWithEnv(path.Join(er.HomeDir(), "clean-all"))
 }
 
@@ -149,59 +229,171 @@ func (er EasyRSA) CleanAll() error {
 // Explicitly set nsCertType to server using the "server"
 // extension in the openssl.cnf file.
 func (er EasyRSA) BuildKeyServer() error {
+	unlock, err := er.Lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
 	return er.pkitool("--server", er.Server)
 }
 
 // Make a certificate/private key pair using a locally generated
-// root certificate.
+// root certificate. Client is recorded in Registry. Name is checked by CheckClientName;
+// existing client is reissued or reported as ClientExistsError according to Reissue policy.
 //
 // Returns list of all generated files
 func (er EasyRSA) BuildClientKeys(name string) (ClientKeyFiles, error) {
-	keys := ClientKeyFiles{Name:name,
+	keys, _, err := er.issueClientKeys(name)
+	return keys, err
+}
+
+// Same as BuildClientKeys but also returns registry record of client (nil if not registered) read under the same
+// lock before issuing
+func (er EasyRSA) issueClientKeys(name string) (ClientKeyFiles, *ClientRecord, error) {
+	keys := er.ClientKeys(name)
+	if err := er.CheckClientName(name); err != nil {
+		return keys, nil, err
+	}
+	unlock, err := er.Lock()
+	if err != nil {
+		return keys, nil, err
+	}
+	defer unlock()
+	err = os.MkdirAll(er.KeysDir(), 0755)
+	if err != nil {
+		return keys, nil, err
+	}
+	rec, registered, err := er.Registry().Get(name)
+	if err != nil {
+		return keys, nil, err
+	}
+	var record *ClientRecord
+	if registered {
+		record = &rec
+	}
+	previous := certSerial(keys.Files.Certificate)
+	exists, err := er.hasValidCertificate(name)
+	if err != nil {
+		return keys, record, err
+	}
+	if exists {
+		if er.Reissue != ReissueRevoke {
+			return keys, record, &ClientExistsError{Name: name}
+		}
+		if err = er.revokeClient(name); err != nil {
+			return keys, record, err
+		}
+	}
+	err = er.pkitool(name)
+	if err != nil {
+		return keys, record, err
+	}
+	if issued := certSerial(keys.Files.Certificate); issued != nil && (previous == nil || issued.Cmp(previous) != 0) {
+		keys.Serial = issued
+	}
+	if err = checkKeyFile(keys.Files.Certificate); err != nil {
+		return keys, record, err
+	}
+	if err = checkKeyFile(keys.Files.Key); err != nil {
+		return keys, record, err
+	}
+	return keys, record, er.Registry().issued(name, keys.Files.Certificate)
+}
+
+// Locations of client key files (files may not exist)
+func (er EasyRSA) ClientKeys(name string) ClientKeyFiles {
+	return ClientKeyFiles{Name:name,
 		Files: KeyPair{
 			Certificate:path.Join(er.KeysDir(), name + ".crt"),
 			Key:path.Join(er.KeysDir(), name + ".key")},
 		SigningRequest:path.Join(er.KeysDir(), name + ".csr"),
 	}
-	err := os.MkdirAll(er.KeysDir(), 0755)
+}
+
+// Location of certificate revocation list. It should be used by server as crl-verify
+func (er EasyRSA) CRLFile() string {
+	return path.Join(er.KeysDir(), "crl.pem")
+}
+
+// Revoke client certificate (like revoke-full), mark it in Registry and regenerate certificate revocation list
+func (er EasyRSA) RevokeClient(name string) error {
+	if err := er.CheckClientName(name); err != nil {
+		return err
+	}
+	unlock, err := er.Lock()
 	if err != nil {
-		return keys, err
+		return err
 	}
-	err = er.pkitool(name)
+	defer unlock()
+	return er.revokeClient(name)
+}
+
+func (er EasyRSA) revokeClient(name string) error {
+	keys := er.ClientKeys(name)
+	if err := checkKeyFile(keys.Files.Certificate); err != nil {
+		return err
+	}
+	cnf, err := er.whichOpenSLLCNF()
 	if err != nil {
-		return keys, err
+		return err
+	}
+	// openssl.cnf of easy-rsa refers KEY_CN
+	if err = er.runWithExtraEnv([]string{"KEY_CN="}, "openssl", "ca", "-revoke", keys.Files.Certificate, "-config", cnf); err != nil {
+		return err
 	}
-	if _, err = os.Stat(keys.Files.Certificate); err != nil {
-		return keys, err
+	if err = er.Registry().revoked(name); err != nil {
+		return err
 	}
-	if _, err = os.Stat(keys.Files.Key); err != nil {
-		return keys, err
+	return er.generateCRL()
+}
+
+// Generate certificate revocation list (CRLFile)
+func (er EasyRSA) GenerateCRL() error {
+	unlock, err := er.Lock()
+	if err != nil {
+		return err
 	}
-	return keys, nil
+	defer unlock()
+	return er.generateCRL()
+}
+
+func (er EasyRSA) generateCRL() error {
+	cnf, err := er.whichOpenSLLCNF()
+	if err != nil {
+		return err
+	}
+	return er.runWithExtraEnv([]string{"KEY_CN="}, "openssl", "ca", "-gencrl", "-out", er.CRLFile(), "-config", cnf)
 }
 
 // Build a root certificate
 func (er EasyRSA) BuildKeyCa() error {
-	err := er.pkitool("--initca")
+	unlock, err := er.Lock()
 	if err != nil {
 		return err
 	}
-	if _, err = os.Stat(path.Join(er.KeysDir(), "ca.crt")); err != nil {
-		return errors.New("CA certificate not created")
+	defer unlock()
+	err = er.pkitool("--initca")
+	if err != nil {
+		return err
 	}
-	if _, err = os.Stat(path.Join(er.KeysDir(), "ca.key")); err != nil {
-		return errors.New("CA key not created")
+	if err = checkKeyFile(path.Join(er.KeysDir(), "ca.crt")); err != nil {
+		return err
 	}
-	return nil
+	return checkKeyFile(path.Join(er.KeysDir(), "ca.key"))
 }
 
 // Build Diffie-Hellman parameters for the server side
 // of an SSL/TLS connection.
 func (er EasyRSA) BuildDH() error {
+	unlock, err := er.Lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
 	return er.runWithEnv(path.Join(er.HomeDir(), "build-dh"))
 }
 
-// Clean all and generate CA, server and Diffie-Hellman keys
+// Clean all and generate CA, server and Diffie-Hellman keys and empty CRL. Existing PKI is destroyed: see EnsureServerKeys
 func (er EasyRSA) BuildAllServerKeys() error {
 	if err := os.MkdirAll(er.KeysDir(), 0755); err != nil {
 		return err
@@ -218,5 +410,124 @@ func (er EasyRSA) BuildAllServerKeys() error {
 	if err := er.BuildDH(); err != nil {
 		return err
 	}
+	return er.GenerateCRL()
+}
+
+// Build only missing parts of PKI: keys directory database, CA, server and Diffie-Hellman keys and CRL.
+// Existing keys are reused, so it is safe to call it repeatedly. Use BuildAllServerKeys to reset PKI
+func (er EasyRSA) EnsureServerKeys() error {
+	if err := er.ensureKeysDir(); err != nil {
+		return err
+	}
+	keys := er.KeyFiles()
+	if !fileExists(keys.CA.Certificate) || !fileExists(keys.CA.Key) {
+		if fileExists(keys.Server.Certificate) {
+			return errors.New("Server certificate exists without CA: reset PKI explicitly")
+		}
+		if err := er.BuildKeyCa(); err != nil {
+			return err
+		}
+	}
+	if !fileExists(keys.Server.Certificate) || !fileExists(keys.Server.Key) {
+		if err := er.BuildKeyServer(); err != nil {
+			return err
+		}
+	}
+	if !fileExists(keys.DiffieHellman) {
+		if err := er.BuildDH(); err != nil {
+			return err
+		}
+	}
+	// server with crl-verify rejects all clients if CRL is missing
+	if !fileExists(er.CRLFile()) {
+		if err := er.GenerateCRL(); err != nil {
+			return err
+		}
+	}
 	return nil
+}
+
+// Create keys directory with empty database (like clean-all) without removing anything
+func (er EasyRSA) ensureKeysDir() error {
+	unlock, err := er.Lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	if err = os.MkdirAll(er.KeysDir(), 0700); err != nil {
+		return err
+	}
+	if !fileExists(er.IndexFile()) {
+		if err = writeFileAtomic(er.IndexFile(), nil, 0644); err != nil {
+			return err
+		}
+	}
+	if serial := path.Join(er.KeysDir(), "serial"); !fileExists(serial) {
+		if err = writeFileAtomic(serial, []byte("01\n"), 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func fileExists(file string) bool {
+	_, err := os.Stat(file)
+	return err == nil
+}
+
+// Index contains valid certificate with common name
+func (er EasyRSA) hasValidCertificate(name string) (bool, error) {
+	entries, err := er.Index()
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	for _, e := range entries {
+		if e.CommonName == name && e.Valid() {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// Location of certificates database (index.txt)
+func (er EasyRSA) IndexFile() string {
+	return path.Join(er.KeysDir(), "index.txt")
+}
+
+// Read and parse certificates database (index.txt) - inventory of all issued certificates
+func (er EasyRSA) Index() ([]IndexEntry, error) {
+	data, err := ioutil.ReadFile(er.IndexFile())
+	if err != nil {
+		return nil, err
+	}
+	var entries []IndexEntry
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Split(line, "\t")
+		if len(fields) < 6 {
+			continue
+		}
+		entry := IndexEntry{Status: fields[0], Serial: fields[3], Subject: fields[5]}
+		entry.Expires, _ = parseIndexTime(fields[1])
+		if fields[2] != "" {
+			entry.Revoked, _ = parseIndexTime(strings.Split(fields[2], ",")[0])
+		}
+		for _, part := range strings.Split(entry.Subject, "/") {
+			if strings.HasPrefix(part, "CN=") {
+				entry.CommonName = strings.TrimPrefix(part, "CN=")
+			}
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// Parse OpenSSL database time: UTCTime (YYMMDDHHMMSSZ) or GeneralizedTime (YYYYMMDDHHMMSSZ)
+func parseIndexTime(value string) (time.Time, error) {
+	if len(value) == 15 {
+		return time.Parse("20060102150405Z", value)
+	}
+	return time.Parse("060102150405Z", value)
 }
\ No newline at end of file
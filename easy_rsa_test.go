@@ -2,8 +2,10 @@ package vpnc
 
 import (
 	"testing"
+	"errors"
 	"path"
 	"os"
+	"io/ioutil"
 )
 
 func getInstance() EasyRSA {
@@ -141,4 +143,129 @@ func TestGetKeyFiles(t *testing.T) {
 	if path.Base(keys.DiffieHellman) != "dh2048.pem" {
 		t.Error("Invalid Diffie-Hellman file name", keys.DiffieHellman)
 	}
-}
\ No newline at end of file
+}
+func TestIndex(t *testing.T) {
+	dir, err := ioutil.TempDir("", "index")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	index := "V\t260101000000Z\t\t01\tunknown\t/C=RU/ST=CR/CN=test.local/emailAddress=vpn@vcontrol.com\n" +
+		"R\t260101000000Z\t160505000000Z,keyCompromise\t02\tunknown\t/C=RU/ST=CR/CN=ivan/emailAddress=vpn@vcontrol.com\n" +
+		"V\t20500101000000Z\t\t03\tunknown\t/C=RU/ST=CR/CN=petr\n"
+	if err = ioutil.WriteFile(path.Join(dir, "index.txt"), []byte(index), 0644); err != nil {
+		t.Fatal(err)
+	}
+	r := getInstance()
+	r.KeyDir = dir
+	entries, err := r.Index()
+	if err != nil {
+		t.Fatal("Read index", err)
+	}
+	if len(entries) != 3 {
+		t.Fatal("Bad number of entries", entries)
+	}
+	if entries[1].CommonName != "ivan" || entries[1].Status != "R" || entries[1].Revoked.Year() != 2016 || entries[1].Serial != "02" {
+		t.Error("Bad revoked entry", entries[1])
+	}
+	if !entries[2].Valid() || entries[2].Expires.Year() != 2050 || entries[2].CommonName != "petr" {
+		t.Error("Bad valid entry", entries[2])
+	}
+	if entries[1].Valid() {
+		t.Error("Revoked entry must be invalid")
+	}
+}
+
+func TestEnsureServerKeys(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ensure")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	r := getInstance()
+	r.KeyDir = dir
+	keys := r.KeyFiles()
+	if err = ioutil.WriteFile(keys.Server.Certificate, []byte("server"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err = r.EnsureServerKeys(); err == nil {
+		t.Error("Server certificate without CA must not be silently reissued")
+	}
+	for _, file := range []string{keys.CA.Certificate, keys.CA.Key, keys.Server.Key, keys.DiffieHellman, r.CRLFile()} {
+		if err = ioutil.WriteFile(file, []byte("existing"), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = ioutil.WriteFile(r.IndexFile(), []byte("V\t20500101000000Z\t\t02\tunknown\t/CN=ivan\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err = r.EnsureServerKeys(); err != nil {
+		t.Fatal("Ensure existing keys", err)
+	}
+	if data, _ := ioutil.ReadFile(keys.CA.Key); string(data) != "existing" {
+		t.Error("CA key changed")
+	}
+	if entries, _ := r.Index(); len(entries) != 1 {
+		t.Error("Index changed", entries)
+	}
+	if data, _ := ioutil.ReadFile(path.Join(dir, "serial")); string(data) != "01\n" {
+		t.Error("Serial not initialized", string(data))
+	}
+}
+
+func TestValidateClientName(t *testing.T) {
+	for _, name := range []string{"ivan", "ivan.petrov", "ivan_laptop-2", "ivan@example.com", "1"} {
+		if err := ValidateClientName(name); err != nil {
+			t.Error("Valid name rejected", name, err)
+		}
+	}
+	for _, name := range []string{"", "../ivan", "ivan/laptop", "ivan petrov", ".ivan", "a..b", "-ivan", string(make([]byte, 65))} {
+		if err := ValidateClientName(name); !errors.Is(err, ErrInvalidClientName) {
+			t.Error("Unsafe name accepted", name)
+		}
+	}
+}
+
+func TestBuildExistingClient(t *testing.T) {
+	dir, err := ioutil.TempDir("", "exists")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	r := getInstance()
+	r.KeyDir = dir
+	if err = ioutil.WriteFile(r.IndexFile(), []byte("V\t20500101000000Z\t\t02\tunknown\t/CN=ivan\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	_, err = r.BuildClientKeys("ivan")
+	var exists *ClientExistsError
+	if !errors.Is(err, ErrClientExists) || !errors.As(err, &exists) || exists.Name != "ivan" {
+		t.Error("Existing client must be reported", err)
+	}
+	if _, err = r.BuildClientKeys("../ivan"); !errors.Is(err, ErrInvalidClientName) {
+		t.Error("Unsafe name must be rejected", err)
+	}
+}
+
+func TestCheckClientName(t *testing.T) {
+	r := getInstance()
+	r.Reissue = ReissueRevoke
+	for _, name := range []string{"ca", "CA", "test.local", "ta", "dh2048", "dh4096", "index", "index.txt.attr", "serial", "serial.old", "crl"} {
+		if err := r.CheckClientName(name); !errors.Is(err, ErrInvalidClientName) {
+			t.Error("Reserved name accepted", name, err)
+		}
+		if _, err := r.BuildClientKeys(name); !errors.Is(err, ErrInvalidClientName) {
+			t.Error("Client with reserved name issued", name, err)
+		}
+	}
+	for _, name := range []string{"ivan", "cathy", "data", "test.local2"} {
+		if err := r.CheckClientName(name); err != nil {
+			t.Error("Valid name rejected", name, err)
+		}
+	}
+	r.Server = "vpn"
+	r.KeyDir = "keys"
+	if err := r.CheckClientName("VPN"); !errors.Is(err, ErrInvalidClientName) {
+		t.Error("Name of server key files accepted", err)
+	}
+}
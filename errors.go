@@ -0,0 +1,91 @@
+package vpnc
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"os/exec"
+)
+
+// Errors of package. Returned errors wrap them, so use errors.Is to check
+var (
+	ErrToolNotFound       = errors.New("Tool not found")              // easy-rsa script, openssl or openvpn is not installed
+	ErrUnsupportedOpenSSL = errors.New("Unsupported OpenSSL version") // No easy-rsa openssl.cnf for installed OpenSSL
+	ErrInvalidField       = errors.New("Invalid field")               // OpenVPNServer field is not set or invalid (see FieldError)
+	ErrClientExists       = errors.New("Client already exists")       // Client with valid certificate already exists
+	ErrInvalidClientName  = errors.New("Invalid client name")         // Client name is not safe (see ValidateClientName)
+	ErrKeyFileMissing     = errors.New("Key file missing")            // Certificate or key was not created or not found
+	ErrInvalidIP          = errors.New("Invalid static IP")           // Address is malformed or outside of VPN network
+	ErrIPConflict         = errors.New("Static IP conflict")          // Address is assigned to another client
+	ErrStaticIPExists     = errors.New("Static IP already set")       // Client already has static IP
+)
+
+// Failed validation of OpenVPNServer field. Matches ErrInvalidField
+type FieldError struct {
+	Field   string // Name of field like Port or Keys.CA.Certificate
+	Message string
+}
+
+func (fe *FieldError) Error() string {
+	return fe.Message
+}
+
+func (fe *FieldError) Is(target error) bool {
+	return target == ErrInvalidField
+}
+
+// Error of BuildClientKeys for existing client. Matches ErrClientExists
+type ClientExistsError struct {
+	Name string
+}
+
+func (ce *ClientExistsError) Error() string {
+	return "Client " + ce.Name + " already exists"
+}
+
+func (ce *ClientExistsError) Is(target error) bool {
+	return target == ErrClientExists
+}
+
+// Certificate or key file not found. Matches ErrKeyFileMissing and wraps original error
+type KeyFileError struct {
+	File string
+	Err  error
+}
+
+func (ke *KeyFileError) Error() string {
+	return "Key file " + ke.File + " missing: " + ke.Err.Error()
+}
+
+func (ke *KeyFileError) Is(target error) bool {
+	return target == ErrKeyFileMissing
+}
+
+func (ke *KeyFileError) Unwrap() error {
+	return ke.Err
+}
+
+// Failed execution of external tool. Matches ErrToolNotFound if tool is not installed and wraps original error
+type ToolError struct {
+	Tool string
+	Err  error
+}
+
+func (te *ToolError) Error() string {
+	return te.Tool + ": " + te.Err.Error()
+}
+
+func (te *ToolError) Is(target error) bool {
+	return target == ErrToolNotFound && (errors.Is(te.Err, exec.ErrNotFound) || errors.Is(te.Err, fs.ErrNotExist))
+}
+
+func (te *ToolError) Unwrap() error {
+	return te.Err
+}
+
+// Check that key file exists
+func checkKeyFile(file string) error {
+	if _, err := os.Stat(file); err != nil {
+		return &KeyFileError{File: file, Err: err}
+	}
+	return nil
+}
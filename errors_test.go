@@ -0,0 +1,46 @@
+package vpnc
+import (
+	"testing"
+	"errors"
+	"os"
+	"os/exec"
+)
+
+func TestFieldError(t *testing.T) {
+	ovpn := OpenVPNServer{Port: 1194, Protocol: "udp"}
+	ovpn.Keys.CA.Certificate = "ca.crt"
+	ovpn.Keys.Server.Key = "server.key"
+	err := ovpn.CheckRequiredFields()
+	var fe *FieldError
+	if !errors.Is(err, ErrInvalidField) || !errors.As(err, &fe) || fe.Field != "Keys.DiffieHellman" {
+		t.Error("Invalid field not reported", err)
+	}
+	if fe != nil && fe.Message != "Diffie-Hellman parameters file (Keys.DiffieHellman) must be set" {
+		t.Error("Message does not name missing field", fe.Message)
+	}
+	ovpn.Protocol = "sctp"
+	if err = ovpn.CheckRequiredFields(); !errors.As(err, &fe) || fe.Field != "Protocol" {
+		t.Error("Invalid protocol not reported", err)
+	}
+	if err = ovpn.checkClientFields(); !errors.As(err, &fe) || fe.Field != "Addresses" {
+		t.Error("Missing addresses not reported", err)
+	}
+}
+
+func TestToolError(t *testing.T) {
+	err := error(&ToolError{Tool: "pkitool", Err: exec.Command("/nonexistent/pkitool").Run()})
+	if !errors.Is(err, ErrToolNotFound) {
+		t.Error("Missing tool not reported", err)
+	}
+	err = &ToolError{Tool: "pkitool", Err: errors.New("exit status 1")}
+	if errors.Is(err, ErrToolNotFound) {
+		t.Error("Failed tool reported as missing", err)
+	}
+}
+
+func TestKeyFileError(t *testing.T) {
+	err := checkKeyFile("/nonexistent/ca.crt")
+	if !errors.Is(err, ErrKeyFileMissing) || !errors.Is(err, os.ErrNotExist) {
+		t.Error("Missing key file not reported", err)
+	}
+}
@@ -0,0 +1,95 @@
+package vpnc
+import (
+	"os"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+)
+
+// Link src to dst or copy it if linking is not possible (different file systems, not supported by FS and e.t.c.).
+// Existing dst is atomically replaced: link is created with temporary name and renamed over dst.
+// Mode is applied only for copied files: linked files share mode with src
+func linkOrCopy(src, dst string, mode os.FileMode) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(dst), "." + filepath.Base(dst) + ".")
+	if err != nil {
+		return err
+	}
+	tmp.Close()
+	defer os.Remove(tmp.Name())
+	if err = os.Remove(tmp.Name()); err != nil {
+		return err
+	}
+	if err = os.Link(src, tmp.Name()); err == nil {
+		return os.Rename(tmp.Name(), dst)
+	}
+	return copyFile(src, dst, mode)
+}
+
+// Copy content of src to dst with specified permissions. Existing dst is atomically replaced
+func copyFile(src, dst string, mode os.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	return replaceFile(dst, mode, false, func(w io.Writer) error {
+		_, err := io.Copy(w, in)
+		return err
+	})
+}
+
+// Atomically write data to file. Mode and ownership of existing file are preserved, new file is created with mode
+func writeFileAtomic(file string, data []byte, mode os.FileMode) error {
+	return writeAtomic(file, mode, func(w io.Writer) error {
+		_, err := w.Write(data)
+		return err
+	})
+}
+
+// Atomically write file by function: content is written to temporary file in the same directory,
+// synced and renamed over target. Readers see old or new content, never partial one.
+// Mode and ownership of existing file are preserved, new file is created with mode
+func writeAtomic(file string, mode os.FileMode, write func(w io.Writer) error) error {
+	return replaceFile(file, mode, true, write)
+}
+
+func replaceFile(file string, mode os.FileMode, keepMode bool, write func(w io.Writer) error) error {
+	// replace target of symlink, not link itself
+	if real, err := filepath.EvalSymlinks(file); err == nil {
+		file = real
+	}
+	info, statErr := os.Stat(file)
+	if statErr == nil && keepMode {
+		mode = info.Mode().Perm()
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(file), "." + filepath.Base(file) + ".")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if err = tmp.Chmod(mode); err != nil {
+		tmp.Close()
+		return err
+	}
+	if statErr == nil {
+		if err = chownLike(tmp, info); err != nil {
+			tmp.Close()
+			return err
+		}
+	}
+	if err = write(tmp); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	if err = os.Rename(tmp.Name(), file); err != nil {
+		return err
+	}
+	return syncDir(filepath.Dir(file))
+}
@@ -0,0 +1,123 @@
+package vpnc
+import (
+	"testing"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+)
+
+func TestCopyFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "copy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	src := path.Join(dir, "src.key")
+	dst := path.Join(dir, "dst.key")
+	if err = ioutil.WriteFile(src, []byte("secret"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err = ioutil.WriteFile(dst, []byte("old long content"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err = copyFile(src, dst, 0600); err != nil {
+		t.Fatal("Copy file", err)
+	}
+	data, err := ioutil.ReadFile(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "secret" {
+		t.Error("Bad copied content", string(data))
+	}
+	info, err := os.Stat(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Error("Bad copied file mode", info.Mode())
+	}
+}
+
+func TestLinkOrCopyReplace(t *testing.T) {
+	dir, err := ioutil.TempDir("", "link")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	src := path.Join(dir, "src")
+	dst := path.Join(dir, "dst")
+	if err = ioutil.WriteFile(src, []byte("new"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err = ioutil.WriteFile(dst, []byte("old"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err = linkOrCopy(src, dst, 0644); err != nil {
+		t.Fatal("Link existing file", err)
+	}
+	if data, _ := ioutil.ReadFile(dst); string(data) != "new" {
+		t.Error("Existing file not replaced")
+	}
+}
+
+func TestWriteAtomic(t *testing.T) {
+	dir, err := ioutil.TempDir("", "atomic")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := path.Join(dir, "server.conf")
+	if err = writeFileAtomic(file, []byte("port 1194\n"), 0640); err != nil {
+		t.Fatal("Write new file", err)
+	}
+	if info, _ := os.Stat(file); info.Mode().Perm() != 0640 {
+		t.Error("Bad mode of new file", info.Mode())
+	}
+	if err = os.Chmod(file, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err = writeFileAtomic(file, []byte("port 443\n"), 0644); err != nil {
+		t.Fatal("Replace file", err)
+	}
+	if info, _ := os.Stat(file); info.Mode().Perm() != 0600 {
+		t.Error("Mode of existing file not preserved", info.Mode())
+	}
+	err = writeAtomic(file, 0644, func(w io.Writer) error {
+		io.WriteString(w, "partial")
+		return errors.New("disk full")
+	})
+	if err == nil {
+		t.Error("Write error must be returned")
+	}
+	if data, _ := ioutil.ReadFile(file); string(data) != "port 443\n" {
+		t.Error("Failed write changed file", string(data))
+	}
+	if files, _ := ioutil.ReadDir(dir); len(files) != 1 {
+		t.Error("Temporary files left", len(files))
+	}
+}
+
+func TestLinkOrCopySameFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "link")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	src := path.Join(dir, "ca.crt")
+	if err = ioutil.WriteFile(src, []byte("ca"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err = linkOrCopy(src, src, 0644); err != nil {
+		t.Fatal("Link file to itself", err)
+	}
+	if data, err := ioutil.ReadFile(src); err != nil || string(data) != "ca" {
+		t.Error("Source file lost", err)
+	}
+	items, _ := ioutil.ReadDir(dir)
+	if len(items) != 1 {
+		t.Error("Temporary file left", len(items))
+	}
+}
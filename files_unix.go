@@ -0,0 +1,30 @@
+//go:build !windows
+// +build !windows
+
+package vpnc
+import (
+	"os"
+	"syscall"
+)
+
+// Set owner of f same as in info. Only root could change owner, so permission errors are ignored
+func chownLike(f *os.File, info os.FileInfo) error {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return nil
+	}
+	if err := f.Chown(int(st.Uid), int(st.Gid)); err != nil && !os.IsPermission(err) {
+		return err
+	}
+	return nil
+}
+
+// Flush directory entries (renames) to disk
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
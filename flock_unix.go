@@ -0,0 +1,21 @@
+//go:build !windows
+// +build !windows
+
+package vpnc
+import (
+	"os"
+	"syscall"
+)
+
+func flock(f *os.File) error {
+	for {
+		err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX)
+		if err != syscall.EINTR {
+			return err
+		}
+	}
+}
+
+func funlock(f *os.File) error {
+	return syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+}
@@ -0,0 +1,12 @@
+package vpnc
+import "os"
+
+// Windows has no flock: only in-process locking is available
+
+func flock(f *os.File) error {
+	return nil
+}
+
+func funlock(f *os.File) error {
+	return nil
+}
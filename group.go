@@ -0,0 +1,212 @@
+package vpnc
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"net"
+)
+
+// Set of OpenVPN server instances sharing one PKI and TLS key, e.g. UDP 1194 for performance and TCP 443 for
+// restrictive networks. Each instance has own configuration file, VPN subnet, PersistIPFile and status file.
+// Order of instances is order of remotes in client configuration
+type ServerGroup struct {
+	Instances    []OpenVPNServer
+	RemoteRandom bool // Clients pick random instance instead of trying them in order
+}
+
+// Default instances: UDP 1194 with 10.8.0.0/24 and TCP 443 with 10.9.0.0/24
+func DefaultInstances() []OpenVPNServer {
+	return []OpenVPNServer{
+		{Protocol: "udp", Port: 1194, Network: "10.8.0.0/24", ClientToClient: true},
+		{Protocol: "tcp", Port: 443, Network: "10.9.0.0/24", ClientToClient: true},
+	}
+}
+
+// Name of instance in group: protocol and port like udp1194. Used for configuration file (udp1194.conf),
+// default PersistIPFile and status file
+func (ovpn OpenVPNServer) InstanceName() string {
+	return ovpn.Protocol + strconv.Itoa(int(ovpn.Port))
+}
+
+// Check instances: each must pass CheckRequiredFields and use same CA and TLS key (clients get key of first
+// instance). Instances must not share protocol and port,
+// VPN subnets, PersistIPFile, status file or management address. Returns *FieldError with field like Instances[1].Network
+func (g ServerGroup) Validate() error {
+	if len(g.Instances) == 0 {
+		return &FieldError{Field: "Instances", Message: "No server instances"}
+	}
+	for i, inst := range g.Instances {
+		field := "Instances[" + strconv.Itoa(i) + "]."
+		if err := inst.CheckRequiredFields(); err != nil {
+			if fe, ok := err.(*FieldError); ok {
+				return &FieldError{Field: field + fe.Field, Message: inst.InstanceName() + ": " + fe.Message}
+			}
+			return err
+		}
+		if inst.Keys.CA.Certificate != g.Instances[0].Keys.CA.Certificate {
+			return &FieldError{Field: field + "Keys.CA.Certificate", Message: inst.InstanceName() + ": instances must share CA"}
+		}
+		if inst.TlsKey != g.Instances[0].TlsKey || inst.TlsCrypt != g.Instances[0].TlsCrypt {
+			return &FieldError{Field: field + "TlsKey", Message: inst.InstanceName() + ": instances must share TLS key and mode"}
+		}
+		for _, prev := range g.Instances[:i] {
+			conflict := ""
+			switch {
+			case inst.Protocol == prev.Protocol && inst.Port == prev.Port && inst.LocalAddr == prev.LocalAddr:
+				conflict = "Port"
+			case overlaps(inst.Pool, prev.Pool) || overlaps(inst.PoolIPv6, prev.PoolIPv6):
+				conflict = "Network"
+			case inst.PersistIPFile != "" && inst.PersistIPFile == prev.PersistIPFile:
+				conflict = "PersistIPFile"
+			case inst.StatusLog() == prev.StatusLog():
+				conflict = "StatusFile"
+			case inst.ManagementAddr != "" && inst.ManagementAddr == prev.ManagementAddr:
+				conflict = "ManagementAddr"
+			}
+			if conflict != "" {
+				return &FieldError{Field: field + conflict,
+					Message: fmt.Sprintf("%s: %s conflicts with %s", inst.InstanceName(), conflict, prev.InstanceName())}
+			}
+		}
+	}
+	return nil
+}
+
+// Subnets of both instances are set and intersect
+func overlaps(a, b func() (*net.IPNet, error)) bool {
+	netA, errA := a()
+	netB, errB := b()
+	if errA != nil || errB != nil || netA == nil || netB == nil {
+		return false
+	}
+	return netA.Contains(netB.IP) || netB.Contains(netA.IP)
+}
+
+// First subnet 10.X.0.0/24 (X from 8) which does not overlap Network of any instance
+func (g ServerGroup) freeNetwork() (string, error) {
+	for x := 8; x < 256; x++ {
+		candidate := OpenVPNServer{Network: "10." + strconv.Itoa(x) + ".0.0/24"}
+		free := true
+		for _, inst := range g.Instances {
+			if inst.Network != "" && overlaps(candidate.Pool, inst.Pool) {
+				free = false
+				break
+			}
+		}
+		if free {
+			return candidate.Network, nil
+		}
+	}
+	return "", &FieldError{Field: "Network", Message: "No free 10.X.0.0/24 subnet"}
+}
+
+// Create configuration of every instance in targetDir as <InstanceName>.conf (see InitialConfigFile)
+func (g ServerGroup) InitialConfig(targetDir string) error {
+	if err := g.Validate(); err != nil {
+		return err
+	}
+	for _, inst := range g.Instances {
+		if err := inst.InitialConfigFile(targetDir, inst.InstanceName() + ".conf"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Server for client configuration: first instance with remotes of other instances in group order.
+// Instance without Addresses is reachable by Addresses of first instance
+func (g ServerGroup) ClientServer() (OpenVPNServer, error) {
+	if len(g.Instances) == 0 {
+		return OpenVPNServer{}, &FieldError{Field: "Instances", Message: "No server instances"}
+	}
+	client := g.Instances[0]
+	client.Remotes = append([]Remote(nil), client.Remotes...)
+	client.RemoteRandom = g.RemoteRandom
+	for _, inst := range g.Instances[1:] {
+		addresses := inst.Addresses
+		if len(addresses) == 0 {
+			addresses = client.Addresses
+		}
+		for _, host := range addresses {
+			client.Remotes = append(client.Remotes, Remote{Host: host, Port: inst.Port, Protocol: inst.Protocol})
+		}
+	}
+	return client, client.checkClientFields()
+}
+
+// Create configuration of several instances (DefaultInstances if not set) for DEBIAN systems in targetDir.
+// Shared PKI and TLS key are reused like in EnsureSimpleDebian, existing instance configurations are read instead
+// of overwriting (only missing crl-verify is added). Not set Network is allocated as first free 10.X.0.0/24
+// (X from 8) not overlapping Network of other instances, PersistIPFile - as ipp-<InstanceName>.txt in targetDir and StatusFile - as openvpn-status-<InstanceName>.log
+func BuildGroupDebian(server string, targetDir string, instances ...OpenVPNServer) (EasyRSA, ServerGroup, error) {
+	group := ServerGroup{Instances: instances}
+	if len(group.Instances) == 0 {
+		group.Instances = DefaultInstances()
+	}
+	targetDir, err := filepath.Abs(targetDir)
+	if err != nil {
+		return EasyRSA{}, group, err
+	}
+	keys := path.Join(targetDir, "keys")
+	if err = os.MkdirAll(keys, 0755); err != nil {
+		return EasyRSA{}, group, err
+	}
+	easyRSA := DefaultEasyRSA(server, targetDir)
+	if err = easyRSA.EnsureServerKeys(); err != nil {
+		return easyRSA, group, err
+	}
+	tlsKey := path.Join(keys, "ta.key")
+	if !fileExists(tlsKey) {
+		tls := OpenVPNServer{}
+		if err = tls.BuildTLSKey(keys); err != nil {
+			return easyRSA, group, err
+		}
+	}
+	var missing []int
+	for i := range group.Instances {
+		inst := &group.Instances[i]
+		conf := path.Join(targetDir, inst.InstanceName() + ".conf")
+		if fileExists(conf) {
+			existing, err := OpenServerConf(conf)
+			if err != nil {
+				return easyRSA, group, err
+			}
+			if err = addCRLVerify(conf, &existing, easyRSA.CRLFile()); err != nil {
+				return easyRSA, group, err
+			}
+			existing.Addresses = inst.Addresses
+			*inst = existing
+			continue
+		}
+		inst.ConfigDir = targetDir
+		inst.Keys = easyRSA.KeyFiles()
+		inst.TlsKey = tlsKey
+		inst.CRLFile = easyRSA.CRLFile()
+		if inst.PersistIPFile == "" {
+			inst.PersistIPFile = path.Join(targetDir, "ipp-" + inst.InstanceName() + ".txt")
+		}
+		if inst.StatusFile == "" {
+			inst.StatusFile = "openvpn-status-" + inst.InstanceName() + ".log"
+		}
+		missing = append(missing, i)
+	}
+	for _, i := range missing {
+		if group.Instances[i].Network == "" {
+			if group.Instances[i].Network, err = group.freeNetwork(); err != nil {
+				return easyRSA, group, err
+			}
+		}
+	}
+	if err = group.Validate(); err != nil {
+		return easyRSA, group, err
+	}
+	for _, i := range missing {
+		inst := group.Instances[i]
+		if err = inst.InitialConfigFile(targetDir, inst.InstanceName() + ".conf"); err != nil {
+			return easyRSA, group, err
+		}
+	}
+	return easyRSA, group, nil
+}
@@ -0,0 +1,170 @@
+package vpnc
+import (
+	"testing"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+)
+
+func getFakeServerGroup(t *testing.T, dir string) ServerGroup {
+	udp := getFakeOVPNServer(t, dir)
+	udp.Addresses = []string{"vpn.example.com"}
+	udp.Network = "10.8.0.0/24"
+	udp.PersistIPFile = path.Join(dir, "ipp-udp1194.txt")
+	udp.StatusFile = "openvpn-status-udp1194.log"
+	tcp := udp
+	tcp.Addresses = nil
+	tcp.Protocol = "tcp"
+	tcp.Port = 443
+	tcp.Network = "10.9.0.0/24"
+	tcp.PersistIPFile = path.Join(dir, "ipp-tcp443.txt")
+	tcp.StatusFile = "openvpn-status-tcp443.log"
+	return ServerGroup{Instances: []OpenVPNServer{udp, tcp}}
+}
+
+func TestServerGroupValidate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "group")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	group := getFakeServerGroup(t, dir)
+	if err = group.Validate(); err != nil {
+		t.Fatal("Valid group", err)
+	}
+	cases := map[string]func(inst *OpenVPNServer){
+		"Instances[1].Network":             func(inst *OpenVPNServer) { inst.Network = "10.8.0.128/25" },
+		"Instances[1].Port":                func(inst *OpenVPNServer) { inst.Protocol = "udp"; inst.Port = 1194 },
+		"Instances[1].PersistIPFile":       func(inst *OpenVPNServer) { inst.PersistIPFile = path.Join(dir, "ipp-udp1194.txt") },
+		"Instances[1].StatusFile":          func(inst *OpenVPNServer) { inst.StatusFile = "" },
+		"Instances[1].Keys.CA.Certificate": func(inst *OpenVPNServer) { inst.Keys.CA.Certificate = "other-ca.crt" },
+		"Instances[1].Protocol":            func(inst *OpenVPNServer) { inst.Protocol = "sctp" },
+		"Instances[1].TlsKey":              func(inst *OpenVPNServer) { inst.TlsCrypt = true },
+	}
+	for field, change := range cases {
+		bad := ServerGroup{Instances: append([]OpenVPNServer(nil), group.Instances...)}
+		bad.Instances[0].StatusFile = ""
+		change(&bad.Instances[1])
+		err := bad.Validate()
+		var fe *FieldError
+		if !errors.As(err, &fe) || fe.Field != field {
+			t.Error("Expected error of", field, "got", err)
+		}
+	}
+}
+
+func TestServerGroupConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "group")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	group := getFakeServerGroup(t, dir)
+	if err = group.InitialConfig(dir); err != nil {
+		t.Fatal("Create group configs", err)
+	}
+	for _, inst := range group.Instances {
+		server, err := OpenServerConf(path.Join(dir, inst.InstanceName() + ".conf"))
+		if err != nil {
+			t.Fatal("Open instance config", err)
+		}
+		if server.Port != inst.Port || server.Protocol != inst.Protocol || server.Network != inst.Network {
+			t.Error("Bad instance config", inst.InstanceName(), server.Port, server.Protocol, server.Network)
+		}
+	}
+
+	group.RemoteRandom = true
+	client, err := group.ClientServer()
+	if err != nil {
+		t.Fatal("Client server of group", err)
+	}
+	data, err := client.InlineClientConf(path.Join(dir, "ivan.crt"), path.Join(dir, "ivan.key"))
+	if err != nil {
+		t.Fatal("Build client config", err)
+	}
+	conf := string(data)
+	udp := strings.Index(conf, "remote vpn.example.com 1194\n")
+	tcp := strings.Index(conf, "remote vpn.example.com 443 tcp\n")
+	if udp == -1 || tcp == -1 || tcp < udp {
+		t.Error("Remotes of instances not listed in order", conf)
+	}
+	if !strings.Contains(conf, "proto udp\n") || !strings.Contains(conf, "remote-random\n") {
+		t.Error("Bad client config", conf)
+	}
+}
+
+// Create fake server keys, CRL and TLS key in dir/keys so BuildGroupDebian does not call easy-rsa
+func getFakeGroupRSA(t *testing.T, dir string) EasyRSA {
+	if err := os.MkdirAll(path.Join(dir, "keys"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	rsa := DefaultEasyRSA("test.local", dir)
+	keys := rsa.KeyFiles()
+	for _, file := range []string{keys.CA.Certificate, keys.CA.Key, keys.Server.Certificate, keys.Server.Key, keys.DiffieHellman, rsa.CRLFile(), path.Join(dir, "keys", "ta.key")} {
+		if err := ioutil.WriteFile(file, []byte("existing"), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return rsa
+}
+
+func TestBuildGroupDebianReuse(t *testing.T) {
+	dir, err := ioutil.TempDir("", "group")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	rsa := getFakeGroupRSA(t, dir)
+	keys := rsa.KeyFiles()
+	conf := "port 1194\nproto udp\nca " + keys.CA.Certificate + "\ncert " + keys.Server.Certificate + "\nkey " + keys.Server.Key +
+		"\ndh " + keys.DiffieHellman + "\ntls-auth " + path.Join(dir, "keys", "ta.key") + " 0\nserver 10.8.0.0 255.255.255.0\n" +
+		"ifconfig-pool-persist " + path.Join(dir, "ipp-udp1194.txt") + "\nstatus openvpn-status-udp1194.log\ncrl-verify " + rsa.CRLFile() + "\n"
+	if err = ioutil.WriteFile(path.Join(dir, "udp1194.conf"), []byte(conf), 0644); err != nil {
+		t.Fatal(err)
+	}
+	_, group, err := BuildGroupDebian("test.local", dir)
+	if err != nil {
+		t.Fatal("Build default group", err)
+	}
+	if data, _ := ioutil.ReadFile(path.Join(dir, "udp1194.conf")); string(data) != conf {
+		t.Error("Existing instance configuration overwritten")
+	}
+	tcp, err := OpenServerConf(path.Join(dir, "tcp443.conf"))
+	if err != nil {
+		t.Fatal("Open created instance config", err)
+	}
+	if tcp.Network != "10.9.0.0/24" || tcp.TlsKey != path.Join(dir, "keys", "ta.key") || tcp.StatusFile != "openvpn-status-tcp443.log" {
+		t.Error("Bad created instance", tcp.Network, tcp.TlsKey, tcp.StatusFile)
+	}
+	if tcp.StatusPath() != path.Join(dir, "openvpn-status-tcp443.log") || group.Instances[1].StatusPath() != tcp.StatusPath() {
+		t.Error("Status file of instance not resolved against config directory", tcp.StatusPath(), group.Instances[1].StatusPath())
+	}
+	if tcp.CRLFile != rsa.CRLFile() {
+		t.Error("Created instance does not verify CRL", tcp.CRLFile)
+	}
+	if len(group.Instances) != 2 || group.Instances[1].PersistIPFile != path.Join(dir, "ipp-tcp443.txt") {
+		t.Error("Bad group", group.Instances)
+	}
+}
+
+func TestBuildGroupDebianFreeNetwork(t *testing.T) {
+	dir, err := ioutil.TempDir("", "group")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	getFakeGroupRSA(t, dir)
+	_, group, err := BuildGroupDebian("test.local", dir,
+		OpenVPNServer{Protocol: "udp", Port: 1194},
+		OpenVPNServer{Protocol: "tcp", Port: 443, Network: "10.8.0.0/16"},
+		OpenVPNServer{Protocol: "udp", Port: 1195})
+	if err != nil {
+		t.Fatal("Build group with explicit network", err)
+	}
+	networks := []string{group.Instances[0].Network, group.Instances[1].Network, group.Instances[2].Network}
+	if networks[0] != "10.9.0.0/24" || networks[1] != "10.8.0.0/16" || networks[2] != "10.10.0.0/24" {
+		t.Error("Allocated networks overlap explicit network", networks)
+	}
+}
@@ -0,0 +1,273 @@
+package vpnc
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"strings"
+)
+
+// Record of PersistIPFile (ifconfig-pool-persist): client common name, IPv4 and IPv6 (OpenVPN 2.4+) addresses
+type StaticIP struct {
+	Client string `json:"client"`
+	IPv4   string `json:"ipv4,omitempty"`
+	IPv6   string `json:"ipv6,omitempty"`
+}
+
+func (sip StaticIP) String() string {
+	line := sip.Client + "," + sip.IPv4
+	if sip.IPv6 != "" {
+		line += "," + sip.IPv6
+	}
+	return line
+}
+
+// Parsed PersistIPFile. Order of records, comments and unknown lines are preserved on write
+type IPPFile struct {
+	lines []*ippLine
+}
+
+type ippLine struct {
+	raw   string    // Original line with line break. Empty for new and changed records
+	entry *StaticIP // Parsed record. Nil for comments and unknown lines
+}
+
+func (l *ippLine) String() string {
+	if l.raw != "" || l.entry == nil {
+		return l.raw
+	}
+	return l.entry.String() + "\n"
+}
+
+// Parse content of PersistIPFile: lines client,ipv4 or client,ipv4,ipv6
+func ParseIPP(r io.Reader) (*IPPFile, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	ipp := &IPPFile{}
+	for _, raw := range strings.SplitAfter(string(data), "\n") {
+		if raw == "" {
+			continue
+		}
+		line := &ippLine{raw: raw}
+		text := strings.TrimSpace(raw)
+		if !strings.HasPrefix(text, "#") && !strings.HasPrefix(text, ";") {
+			fields := strings.Split(text, ",")
+			if len(fields) == 2 || len(fields) == 3 {
+				entry := &StaticIP{Client: strings.TrimSpace(fields[0]), IPv4: strings.TrimSpace(fields[1])}
+				if len(fields) == 3 {
+					entry.IPv6 = strings.TrimSpace(fields[2])
+				}
+				if entry.Client != "" {
+					line.entry = entry
+				}
+			}
+		}
+		ipp.lines = append(ipp.lines, line)
+	}
+	// last line without line break
+	if n := len(ipp.lines); n > 0 && !strings.HasSuffix(ipp.lines[n - 1].raw, "\n") {
+		ipp.lines[n - 1].raw += "\n"
+	}
+	return ipp, nil
+}
+
+// Read and parse PersistIPFile
+func ReadIPP(file string) (*IPPFile, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ParseIPP(f)
+}
+
+// Records in file order
+func (ipp *IPPFile) Entries() []StaticIP {
+	var entries []StaticIP
+	for _, line := range ipp.lines {
+		if line.entry != nil {
+			entries = append(entries, *line.entry)
+		}
+	}
+	return entries
+}
+
+// Find record of client
+func (ipp *IPPFile) Get(client string) (StaticIP, bool) {
+	for _, line := range ipp.lines {
+		if line.entry != nil && line.entry.Client == client {
+			return *line.entry, true
+		}
+	}
+	return StaticIP{}, false
+}
+
+// Replace record of client in place or append new one
+func (ipp *IPPFile) Set(entry StaticIP) {
+	for _, line := range ipp.lines {
+		if line.entry != nil && line.entry.Client == entry.Client {
+			if *line.entry != entry {
+				*line.entry = entry
+				line.raw = ""
+			}
+			return
+		}
+	}
+	ipp.lines = append(ipp.lines, &ippLine{entry: &entry})
+}
+
+// Remove all records of client. Returns false if client has no records
+func (ipp *IPPFile) Remove(client string) bool {
+	var kept []*ippLine
+	for _, line := range ipp.lines {
+		if line.entry == nil || line.entry.Client != client {
+			kept = append(kept, line)
+		}
+	}
+	removed := len(kept) != len(ipp.lines)
+	ipp.lines = kept
+	return removed
+}
+
+// Write file content
+func (ipp *IPPFile) WriteTo(w io.Writer) (int64, error) {
+	var total int64
+	for _, line := range ipp.lines {
+		n, err := io.WriteString(w, line.String())
+		total += int64(n)
+		if err != nil {
+			return total, err
+		}
+	}
+	return total, nil
+}
+
+// Atomically write file
+func (ipp *IPPFile) Save(file string) error {
+	buf := &bytes.Buffer{}
+	if _, err := ipp.WriteTo(buf); err != nil {
+		return err
+	}
+	return writeFileAtomic(file, buf.Bytes(), 0600)
+}
+
+// Records of PersistIPFile in file order
+func (ovpn OpenVPNServer) StaticIPs() ([]StaticIP, error) {
+	ipp, err := ReadIPP(ovpn.PersistIPFile)
+	if err != nil {
+		return nil, err
+	}
+	return ipp.Entries(), nil
+}
+
+// Set static addresses of client in PersistIPFile: existing record is updated in place, otherwise new one is added.
+// Addresses are checked by ValidateStaticIP. Returns error wrapping ErrIPConflict if address is used by another client
+func (ovpn OpenVPNServer) SetStaticIP(entry StaticIP) error {
+	return ovpn.writeStaticIP(entry, true)
+}
+
+func (ovpn OpenVPNServer) writeStaticIP(entry StaticIP, update bool) error {
+	if err := ovpn.ValidateStaticIP(entry); err != nil {
+		return err
+	}
+	unlock, err := ovpn.lockStaticIP()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	ipp, err := ReadIPP(ovpn.PersistIPFile)
+	if err != nil {
+		return err
+	}
+	for _, e := range ipp.Entries() {
+		if e.Client == entry.Client {
+			if !update {
+				return fmt.Errorf("%w: client %s has %s", ErrStaticIPExists, e.Client, e.IPv4)
+			}
+			continue
+		}
+		if sameIP(e.IPv4, entry.IPv4) {
+			return fmt.Errorf("%w: %s is assigned to %s", ErrIPConflict, entry.IPv4, e.Client)
+		}
+		if sameIP(e.IPv6, entry.IPv6) {
+			return fmt.Errorf("%w: %s is assigned to %s", ErrIPConflict, entry.IPv6, e.Client)
+		}
+	}
+	ipp.Set(entry)
+	return ipp.Save(ovpn.PersistIPFile)
+}
+
+// Check addresses of static IP record: IPv4 is required and must be host address in Pool (not network, server
+// or broadcast address), IPv6 is optional and allowed only with PoolIPv6. Returns error wrapping ErrInvalidIP
+func (ovpn OpenVPNServer) ValidateStaticIP(entry StaticIP) error {
+	if err := ValidateClientName(entry.Client); err != nil {
+		return err
+	}
+	pool, err := ovpn.Pool()
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(entry.IPv4).To4()
+	if ip == nil {
+		return fmt.Errorf("%w: %q is not IPv4 address", ErrInvalidIP, entry.IPv4)
+	}
+	if err = checkHostIP(ip, pool); err != nil {
+		return err
+	}
+	if entry.IPv6 == "" {
+		return nil
+	}
+	ip = net.ParseIP(entry.IPv6)
+	if ip == nil || ip.To4() != nil {
+		return fmt.Errorf("%w: %q is not IPv6 address", ErrInvalidIP, entry.IPv6)
+	}
+	pool6, err := ovpn.PoolIPv6()
+	if err != nil {
+		return err
+	}
+	if pool6 == nil {
+		return fmt.Errorf("%w: %s is IPv6 address but server has no IPv6 network", ErrInvalidIP, ip)
+	}
+	return checkHostIP(ip, pool6)
+}
+
+// Address is inside pool and is not network, server (first) or broadcast (last IPv4) address
+func checkHostIP(ip net.IP, pool *net.IPNet) error {
+	if !pool.Contains(ip) {
+		return fmt.Errorf("%w: %s is outside of VPN network %s", ErrInvalidIP, ip, pool)
+	}
+	network := pool.IP.Mask(pool.Mask)
+	server := make(net.IP, len(network))
+	broadcast := make(net.IP, len(network))
+	copy(server, network)
+	for i := len(server) - 1; i >= 0; i-- {
+		server[i]++
+		if server[i] != 0 {
+			break
+		}
+	}
+	for i := range network {
+		broadcast[i] = network[i] | ^pool.Mask[i]
+	}
+	switch {
+	case ip.Equal(network):
+		return fmt.Errorf("%w: %s is network address of %s", ErrInvalidIP, ip, pool)
+	case ip.Equal(server):
+		return fmt.Errorf("%w: %s is server address of %s", ErrInvalidIP, ip, pool)
+	case ip.Equal(broadcast) && ip.To4() != nil:
+		return fmt.Errorf("%w: %s is broadcast address of %s", ErrInvalidIP, ip, pool)
+	}
+	return nil
+}
+
+// Both addresses are set and equal
+func sameIP(a, b string) bool {
+	if a == "" || b == "" {
+		return false
+	}
+	return net.ParseIP(a).Equal(net.ParseIP(b))
+}
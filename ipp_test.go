@@ -0,0 +1,139 @@
+package vpnc
+import (
+	"testing"
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+)
+
+const testIPP = "# static addresses\nivan,10.8.0.4\npetr,10.8.0.8,fd00::1000\r\n\nanna,10.8.0.12"
+
+func TestParseIPP(t *testing.T) {
+	ipp, err := ParseIPP(strings.NewReader(testIPP))
+	if err != nil {
+		t.Fatal("Parse", err)
+	}
+	entries := ipp.Entries()
+	if len(entries) != 3 {
+		t.Fatal("Bad number of entries", entries)
+	}
+	if entries[1] != (StaticIP{Client: "petr", IPv4: "10.8.0.8", IPv6: "fd00::1000"}) {
+		t.Error("Bad IPv6 entry", entries[1])
+	}
+	if entries[2].Client != "anna" || entries[2].IPv4 != "10.8.0.12" {
+		t.Error("Bad last entry", entries[2])
+	}
+	buf := &bytes.Buffer{}
+	ipp.WriteTo(buf)
+	if buf.String() != testIPP + "\n" {
+		t.Error("Unchanged file must be written as is:\n" + buf.String())
+	}
+
+	ipp.Set(StaticIP{Client: "ivan", IPv4: "10.8.0.20", IPv6: "fd00::20"})
+	ipp.Set(StaticIP{Client: "olga", IPv4: "10.8.0.24"})
+	if !ipp.Remove("anna") || ipp.Remove("nobody") {
+		t.Error("Bad result of remove")
+	}
+	buf.Reset()
+	ipp.WriteTo(buf)
+	expected := "# static addresses\nivan,10.8.0.20,fd00::20\npetr,10.8.0.8,fd00::1000\r\n\nolga,10.8.0.24\n"
+	if buf.String() != expected {
+		t.Error("Bad modified file:\n" + buf.String())
+	}
+}
+
+func TestRemoveStaticIPKeepsIPv6(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ipp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ovpn := OpenVPNServer{PersistIPFile: path.Join(dir, "ipp.txt")}
+	if err = ioutil.WriteFile(ovpn.PersistIPFile, []byte(testIPP), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err = ovpn.RemoveStaticIP("ivan"); err != nil {
+		t.Fatal("Remove", err)
+	}
+	entries, err := ovpn.StaticIPs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 || entries[0].Client != "petr" || entries[0].IPv6 != "fd00::1000" || entries[1].Client != "anna" {
+		t.Error("Records lost or reordered", entries)
+	}
+	if data, _ := ioutil.ReadFile(ovpn.PersistIPFile); !strings.HasPrefix(string(data), "# static addresses\n") {
+		t.Error("Comment lost", string(data))
+	}
+}
+
+func TestValidateStaticIP(t *testing.T) {
+	ovpn := OpenVPNServer{NetworkIPv6: "fd00::/64"}
+	valid := []StaticIP{
+		{Client: "ivan", IPv4: "10.8.0.2"},
+		{Client: "ivan", IPv4: "10.8.0.254", IPv6: "fd00::1000"},
+	}
+	for _, entry := range valid {
+		if err := ovpn.ValidateStaticIP(entry); err != nil {
+			t.Error("Valid address rejected", entry, err)
+		}
+	}
+	invalid := []StaticIP{
+		{Client: "ivan", IPv4: "10.8.0"},
+		{Client: "ivan", IPv4: "10.8.1.2"},
+		{Client: "ivan", IPv4: "10.8.0.0"},
+		{Client: "ivan", IPv4: "10.8.0.1"},
+		{Client: "ivan", IPv4: "10.8.0.255"},
+		{Client: "ivan", IPv4: "fd00::2"},
+		{Client: "ivan", IPv4: "10.8.0.2", IPv6: "fd01::2"},
+		{Client: "ivan", IPv4: "10.8.0.2", IPv6: "fd00::1"},
+	}
+	for _, entry := range invalid {
+		if err := ovpn.ValidateStaticIP(entry); !errors.Is(err, ErrInvalidIP) {
+			t.Error("Invalid address accepted", entry, err)
+		}
+	}
+	noIPv6 := OpenVPNServer{}
+	if err := noIPv6.ValidateStaticIP(StaticIP{Client: "ivan", IPv4: "10.8.0.2", IPv6: "fd00::2"}); !errors.Is(err, ErrInvalidIP) {
+		t.Error("IPv6 address accepted without IPv6 network", err)
+	}
+	ovpn.Network = "192.168.100.0/25"
+	if err := ovpn.ValidateStaticIP(StaticIP{Client: "ivan", IPv4: "192.168.100.127"}); !errors.Is(err, ErrInvalidIP) {
+		t.Error("Broadcast address of custom network accepted", err)
+	}
+	if err := ovpn.ValidateStaticIP(StaticIP{Client: "ivan", IPv4: "192.168.100.100"}); err != nil {
+		t.Error("Address of custom network rejected", err)
+	}
+}
+
+func TestSetStaticIP(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ipp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ovpn := OpenVPNServer{PersistIPFile: path.Join(dir, "ipp.txt")}
+	if err = ioutil.WriteFile(ovpn.PersistIPFile, []byte(testIPP), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err = ovpn.AddStaticIP("ivan", "10.8.0.40"); !errors.Is(err, ErrStaticIPExists) {
+		t.Error("Duplicated client accepted", err)
+	}
+	if err = ovpn.AddStaticIP("olga", "10.8.0.8"); !errors.Is(err, ErrIPConflict) {
+		t.Error("Duplicated address accepted", err)
+	}
+	if err = ovpn.SetStaticIP(StaticIP{Client: "ivan", IPv4: "10.8.0.40"}); err != nil {
+		t.Fatal("Update static IP", err)
+	}
+	if err = ovpn.AddStaticIP("olga", "10.8.0.4"); err != nil {
+		t.Fatal("Add released address", err)
+	}
+	data, _ := ioutil.ReadFile(ovpn.PersistIPFile)
+	expected := "# static addresses\nivan,10.8.0.40\npetr,10.8.0.8,fd00::1000\r\n\nanna,10.8.0.12\nolga,10.8.0.4\n"
+	if string(data) != expected {
+		t.Error("Bad file after update:\n" + string(data))
+	}
+}
@@ -0,0 +1,402 @@
+package vpnc
+import (
+	"bytes"
+	"crypto"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Severity of lint finding
+type Severity int
+
+const (
+	SeverityInfo    Severity = iota // Just notice
+	SeverityWarning                 // Works, but should be fixed
+	SeverityError                   // Server will not start or will not work properly
+)
+
+func (s Severity) String() string {
+	switch s {
+	case SeverityWarning: return "warning"
+	case SeverityError: return "error"
+	}
+	return "info"
+}
+
+// Codes of lint findings
+const (
+	LintKeyMissing      = "key-missing"       // Referenced key file doesn't exist or unreadable
+	LintKeyInvalid      = "key-invalid"       // Key or certificate could not be parsed
+	LintKeyMismatch     = "key-mismatch"      // Private key doesn't match certificate
+	LintCertExpired     = "cert-expired"      // Certificate expired or not yet valid
+	LintCertExpiresSoon = "cert-expires-soon" // Certificate expires in LintExpireWarning
+	LintDeprecated      = "deprecated"        // Deprecated or insecure directive
+	LintSubnetOverlap   = "subnet-overlap"    // VPN pool overlaps with routes or routes overlap each other
+	LintBadSubnet       = "bad-subnet"        // Network or mask could not be parsed
+	LintBadPort         = "bad-port"          // Port out of range
+	LintBadProtocol     = "bad-protocol"      // Unknown protocol or option is not compatible with protocol
+)
+
+// Period before certificate expiration when warning is reported
+var LintExpireWarning = 30 * 24 * time.Hour
+
+// Single result of configuration validation
+type Finding struct {
+	Severity  Severity
+	Code      string // One of Lint* constants
+	Directive string // Name of directive caused the finding. Optional
+	Message   string // Human readable description
+}
+
+func (f Finding) String() string {
+	text := f.Severity.String() + " [" + f.Code + "]"
+	if f.Directive != "" {
+		text += " " + f.Directive + ":"
+	}
+	return text + " " + f.Message
+}
+
+// Check if there is at least one finding with error severity
+func HasErrors(findings []Finding) bool {
+	for _, f := range findings {
+		if f.Severity == SeverityError {
+			return true
+		}
+	}
+	return false
+}
+
+// Read and validate server configuration file. Relative paths are resolved against directory of file
+func LintServerConf(serverConf string) ([]Finding, error) {
+	cfg, err := ReadConfig(serverConf)
+	if err != nil {
+		return nil, err
+	}
+	return LintConfig(cfg, filepath.Dir(serverConf)), nil
+}
+
+// Validate parsed server configuration: key files, certificates, deprecated directives,
+// subnets and port/protocol. Relative paths are resolved against baseDir
+func LintConfig(cfg *Config, baseDir string) []Finding {
+	l := &linter{cfg: cfg, baseDir: baseDir, now: time.Now()}
+	l.checkKeys()
+	l.checkDeprecated()
+	l.checkSubnets()
+	l.checkPortProtocol()
+	return l.findings
+}
+
+type linter struct {
+	cfg      *Config
+	baseDir  string
+	now      time.Time
+	findings []Finding
+}
+
+func (l *linter) add(severity Severity, code, directive, message string) {
+	l.findings = append(l.findings, Finding{Severity: severity, Code: code, Directive: directive, Message: message})
+}
+
+// Content of key file or inline block referenced by directive. Returns nil if directive is not set
+func (l *linter) readKey(name string) []byte {
+	node := l.cfg.Get(name)
+	if node == nil {
+		return nil
+	}
+	if node.Kind == NodeInline {
+		return []byte(node.Body)
+	}
+	if len(node.Args) == 0 {
+		l.add(SeverityError, LintKeyMissing, name, "file is not specified")
+		return nil
+	}
+	file := node.Args[0]
+	if name == "dh" && file == "none" {
+		return nil
+	}
+	if !filepath.IsAbs(file) {
+		file = filepath.Join(l.baseDir, file)
+	}
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		l.add(SeverityError, LintKeyMissing, name, err.Error())
+		return nil
+	}
+	return data
+}
+
+func (l *linter) checkKeys() {
+	for _, name := range []string{"dh", "tls-auth", "tls-crypt", "crl-verify"} {
+		l.readKey(name)
+	}
+	if l.cfg.Get("ca") == nil {
+		l.add(SeverityError, LintKeyMissing, "ca", "CA certificate is not specified")
+	}
+	if ca := l.readKey("ca"); ca != nil {
+		if cert, err := parseCertificate(ca); err != nil {
+			l.add(SeverityError, LintKeyInvalid, "ca", err.Error())
+		} else {
+			l.checkValidity("ca", cert)
+		}
+	}
+	certData := l.readKey("cert")
+	keyData := l.readKey("key")
+	if certData == nil {
+		return
+	}
+	cert, err := parseCertificate(certData)
+	if err != nil {
+		l.add(SeverityError, LintKeyInvalid, "cert", err.Error())
+		return
+	}
+	l.checkValidity("cert", cert)
+	if keyData == nil {
+		return
+	}
+	key, err := parsePrivateKey(keyData)
+	if err != nil {
+		l.add(SeverityError, LintKeyInvalid, "key", err.Error())
+		return
+	}
+	certPub, err := x509.MarshalPKIXPublicKey(cert.PublicKey)
+	if err != nil {
+		l.add(SeverityError, LintKeyInvalid, "cert", err.Error())
+		return
+	}
+	keyPub, err := x509.MarshalPKIXPublicKey(key.Public())
+	if err != nil {
+		l.add(SeverityError, LintKeyInvalid, "key", err.Error())
+		return
+	}
+	if !bytes.Equal(certPub, keyPub) {
+		l.add(SeverityError, LintKeyMismatch, "key", "private key doesn't match server certificate")
+	}
+}
+
+func (l *linter) checkValidity(directive string, cert *x509.Certificate) {
+	switch {
+	case l.now.After(cert.NotAfter):
+		l.add(SeverityError, LintCertExpired, directive, "certificate expired at " + cert.NotAfter.Format(time.RFC3339))
+	case l.now.Before(cert.NotBefore):
+		l.add(SeverityError, LintCertExpired, directive, "certificate is not valid before " + cert.NotBefore.Format(time.RFC3339))
+	case l.now.Add(LintExpireWarning).After(cert.NotAfter):
+		l.add(SeverityWarning, LintCertExpiresSoon, directive, "certificate expires at " + cert.NotAfter.Format(time.RFC3339))
+	}
+}
+
+func (l *linter) checkDeprecated() {
+	if l.cfg.Has("comp-lzo") {
+		l.add(SeverityWarning, LintDeprecated, "comp-lzo", "compression is deprecated and vulnerable to VORACLE attack")
+	}
+	if l.cfg.Has("ns-cert-type") {
+		l.add(SeverityWarning, LintDeprecated, "ns-cert-type", "use remote-cert-tls instead")
+	}
+	for _, name := range []string{"cipher", "data-ciphers", "ncp-ciphers"} {
+		for _, node := range l.cfg.GetAll(name) {
+			for _, arg := range node.Args {
+				for _, cipher := range strings.Split(arg, ":") {
+					upper := strings.ToUpper(cipher)
+					if strings.HasPrefix(upper, "BF-") || strings.HasPrefix(upper, "DES-") || upper == "DES" || strings.HasPrefix(upper, "RC2-") {
+						l.add(SeverityWarning, LintDeprecated, name, "weak 64-bit block cipher " + cipher + " (SWEET32)")
+					}
+				}
+			}
+		}
+	}
+	if l.cfg.Has("tls-auth") {
+		auth := strings.ToUpper(l.cfg.Value("auth"))
+		if auth == "" || auth == "SHA1" || auth == "SHA" || auth == "MD5" {
+			if auth == "" {
+				auth = "SHA1 (default)"
+			}
+			l.add(SeverityWarning, LintDeprecated, "tls-auth", "HMAC digest " + auth + " is weak, use auth SHA256 or tls-crypt")
+		}
+	}
+}
+
+type lintNet struct {
+	directive string
+	network   *net.IPNet
+}
+
+func (l *linter) checkSubnets() {
+	var pools, routes, pushRoutes []lintNet
+	for _, node := range l.cfg.Nodes {
+		if node.Kind != NodeDirective {
+			continue
+		}
+		switch node.Name {
+		case "server", "server-bridge":
+			if len(node.Args) >= 2 {
+				if n := l.parseNet(node.Name, node.Args[0], node.Args[1]); n != nil {
+					pools = append(pools, lintNet{node.Name, n})
+				}
+			}
+		case "server-ipv6", "ifconfig-ipv6-pool":
+			if len(node.Args) >= 1 {
+				if _, n, err := net.ParseCIDR(node.Args[0]); err != nil {
+					l.add(SeverityError, LintBadSubnet, node.Name, err.Error())
+				} else {
+					pools = append(pools, lintNet{node.Name, n})
+				}
+			}
+		case "route":
+			mask := "255.255.255.255"
+			if len(node.Args) >= 2 {
+				mask = node.Args[1]
+			}
+			if len(node.Args) >= 1 {
+				if n := l.parseNet("route", node.Args[0], mask); n != nil {
+					routes = append(routes, lintNet{"route", n})
+				}
+			}
+		case "route-ipv6":
+			if len(node.Args) >= 1 {
+				if _, n, err := net.ParseCIDR(node.Args[0]); err != nil {
+					l.add(SeverityError, LintBadSubnet, node.Name, err.Error())
+				} else {
+					routes = append(routes, lintNet{"route-ipv6", n})
+				}
+			}
+		case "push":
+			if len(node.Args) == 0 {
+				continue
+			}
+			parts := strings.Fields(node.Args[0])
+			if len(parts) >= 2 && parts[0] == "route" {
+				mask := "255.255.255.255"
+				if len(parts) >= 3 {
+					mask = parts[2]
+				}
+				if n := l.parseNet("push route", parts[1], mask); n != nil {
+					pushRoutes = append(pushRoutes, lintNet{"push", n})
+				}
+			} else if len(parts) >= 2 && parts[0] == "route-ipv6" {
+				if _, n, err := net.ParseCIDR(parts[1]); err != nil {
+					l.add(SeverityError, LintBadSubnet, "push", err.Error())
+				} else {
+					pushRoutes = append(pushRoutes, lintNet{"push", n})
+				}
+			}
+		}
+	}
+	for _, pool := range pools {
+		for _, route := range append(append([]lintNet{}, routes...), pushRoutes...) {
+			if netOverlaps(pool.network, route.network) {
+				l.add(SeverityError, LintSubnetOverlap, route.directive, route.network.String() + " overlaps VPN pool " + pool.network.String())
+			}
+		}
+	}
+	for _, group := range [][]lintNet{routes, pushRoutes} {
+		for i := 0; i < len(group); i++ {
+			for j := i + 1; j < len(group); j++ {
+				if netOverlaps(group[i].network, group[j].network) {
+					l.add(SeverityWarning, LintSubnetOverlap, group[j].directive, group[j].network.String() + " overlaps " + group[i].network.String())
+				}
+			}
+		}
+	}
+}
+
+func (l *linter) parseNet(directive, addr, mask string) *net.IPNet {
+	ip := net.ParseIP(addr).To4()
+	m := net.ParseIP(mask).To4()
+	if ip == nil || m == nil {
+		l.add(SeverityError, LintBadSubnet, directive, "invalid network " + addr + " " + mask)
+		return nil
+	}
+	ipMask := net.IPMask(m)
+	if ones, bits := ipMask.Size(); ones == 0 && bits == 0 {
+		l.add(SeverityError, LintBadSubnet, directive, "invalid netmask " + mask)
+		return nil
+	}
+	return &net.IPNet{IP: ip.Mask(ipMask), Mask: ipMask}
+}
+
+var serverProtocols = []string{"udp", "udp4", "udp6", "tcp", "tcp4", "tcp6", "tcp-server", "tcp4-server", "tcp6-server"}
+
+func (l *linter) checkPortProtocol() {
+	for _, name := range []string{"port", "lport"} {
+		for _, node := range l.cfg.GetAll(name) {
+			if len(node.Args) == 0 {
+				l.add(SeverityError, LintBadPort, name, "port is not specified")
+				continue
+			}
+			port, err := strconv.ParseUint(node.Args[0], 10, 16)
+			if err != nil || port == 0 {
+				l.add(SeverityError, LintBadPort, name, "port must be in range 1-65535: " + node.Args[0])
+			}
+		}
+	}
+	proto := strings.ToLower(l.cfg.Value("proto"))
+	if proto == "" {
+		proto = "udp"
+	}
+	known := false
+	for _, p := range serverProtocols {
+		known = known || p == proto
+	}
+	if !known {
+		l.add(SeverityError, LintBadProtocol, "proto", "unsupported server protocol " + proto)
+		return
+	}
+	if strings.HasPrefix(proto, "tcp") {
+		for _, name := range []string{"explicit-exit-notify", "fragment"} {
+			if l.cfg.Has(name) {
+				l.add(SeverityError, LintBadProtocol, name, "can be used only with UDP, but protocol is " + proto)
+			}
+		}
+	}
+}
+
+func netOverlaps(a, b *net.IPNet) bool {
+	return a.Contains(b.IP) || b.Contains(a.IP)
+}
+
+// Parse first certificate from PEM data (text before PEM block is ignored)
+func parseCertificate(data []byte) (*x509.Certificate, error) {
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			return nil, errors.New("no certificate found")
+		}
+		if block.Type == "CERTIFICATE" {
+			return x509.ParseCertificate(block.Bytes)
+		}
+	}
+}
+
+// Parse first private key (PKCS#1, PKCS#8 or EC) from PEM data
+func parsePrivateKey(data []byte) (crypto.Signer, error) {
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			return nil, errors.New("no private key found")
+		}
+		switch block.Type {
+		case "RSA PRIVATE KEY":
+			return x509.ParsePKCS1PrivateKey(block.Bytes)
+		case "EC PRIVATE KEY":
+			return x509.ParseECPrivateKey(block.Bytes)
+		case "PRIVATE KEY":
+			key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+			if err != nil {
+				return nil, err
+			}
+			signer, ok := key.(crypto.Signer)
+			if !ok {
+				return nil, errors.New("unsupported private key type")
+			}
+			return signer, nil
+		}
+	}
+}
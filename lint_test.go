@@ -0,0 +1,162 @@
+package vpnc
+import (
+	"testing"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// Generate self-signed certificate and key with specified expiration in dir. Returns cert and key file names
+func writeTestCert(t *testing.T, dir, name string, notAfter time.Time) (string, string) {
+	return writeTestCertSerial(t, dir, name, notAfter, 1)
+}
+
+// Same as writeTestCert with specified serial number
+func writeTestCertSerial(t *testing.T, dir, name string, notAfter time.Time, serial int64) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal("Generate key", err)
+	}
+	notBefore := time.Now().Add(-time.Hour)
+	if notBefore.After(notAfter) {
+		notBefore = notAfter.Add(-24 * time.Hour)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    notBefore,
+		NotAfter:     notAfter,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal("Create certificate", err)
+	}
+	keyDer, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal("Marshal key", err)
+	}
+	certFile := path.Join(dir, name + ".crt")
+	keyFile := path.Join(dir, name + ".key")
+	if err = ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err = ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDer}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile
+}
+
+func hasFinding(findings []Finding, code, directive string) bool {
+	for _, f := range findings {
+		if f.Code == code && f.Directive == directive {
+			return true
+		}
+	}
+	return false
+}
+
+func TestLintValidConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lint")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	writeTestCert(t, dir, "ca", time.Now().Add(10 * 365 * 24 * time.Hour))
+	writeTestCert(t, dir, "server", time.Now().Add(365 * 24 * time.Hour))
+	ioutil.WriteFile(path.Join(dir, "dh.pem"), []byte("dh"), 0644)
+	ioutil.WriteFile(path.Join(dir, "ta.key"), []byte("ta"), 0600)
+	conf := "port 1194\nproto udp\nca ca.crt\ncert server.crt\nkey server.key\ndh dh.pem\n" +
+		"tls-crypt ta.key\nserver 10.8.0.0 255.255.255.0\npush \"route 192.168.1.0 255.255.255.0\"\nexplicit-exit-notify 1\n"
+	if err = ioutil.WriteFile(path.Join(dir, "server.conf"), []byte(conf), 0644); err != nil {
+		t.Fatal(err)
+	}
+	findings, err := LintServerConf(path.Join(dir, "server.conf"))
+	if err != nil {
+		t.Fatal("Lint config", err)
+	}
+	if len(findings) != 0 {
+		t.Error("Unexpected findings", findings)
+	}
+}
+
+func TestLintBadConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lint")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	writeTestCert(t, dir, "ca", time.Now().Add(10 * 24 * time.Hour))
+	writeTestCert(t, dir, "server", time.Now().Add(-time.Hour))
+	writeTestCert(t, dir, "other", time.Now().Add(365 * 24 * time.Hour))
+	conf := strings.Join([]string{
+		"port 70000",
+		"proto tcp",
+		"ca ca.crt",
+		"cert server.crt",
+		"key other.key",
+		"dh missing.pem",
+		"tls-auth ta.key 0",
+		"comp-lzo",
+		"cipher BF-CBC",
+		"server 10.8.0.0 255.255.255.0",
+		"push \"route 10.8.0.128 255.255.255.128\"",
+		"route 192.168.0.0 255.255.0.0",
+		"route 192.168.1.0 255.255.255.0",
+		"explicit-exit-notify 1",
+	}, "\n")
+	cfg, err := ParseConfig(strings.NewReader(conf))
+	if err != nil {
+		t.Fatal("Parse config", err)
+	}
+	findings := LintConfig(cfg, dir)
+	for _, c := range []struct{ code, directive string }{
+		{LintBadPort, "port"},
+		{LintKeyMissing, "dh"},
+		{LintKeyMissing, "tls-auth"},
+		{LintKeyMismatch, "key"},
+		{LintCertExpired, "cert"},
+		{LintCertExpiresSoon, "ca"},
+		{LintDeprecated, "comp-lzo"},
+		{LintDeprecated, "cipher"},
+		{LintDeprecated, "tls-auth"},
+		{LintSubnetOverlap, "push"},
+		{LintSubnetOverlap, "route"},
+		{LintBadProtocol, "explicit-exit-notify"},
+	} {
+		if !hasFinding(findings, c.code, c.directive) {
+			t.Error("Expected finding", c.code, "for", c.directive)
+		}
+	}
+	if !HasErrors(findings) {
+		t.Error("Findings must contain errors")
+	}
+	t.Log(findings)
+}
+
+func TestLintInlineKeys(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lint")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	certFile, keyFile := writeTestCert(t, dir, "server", time.Now().Add(365 * 24 * time.Hour))
+	certData, _ := ioutil.ReadFile(certFile)
+	keyData, _ := ioutil.ReadFile(keyFile)
+	conf := "proto udp6\ndh none\n<ca>\n" + string(certData) + "</ca>\n<cert>\n" + string(certData) + "</cert>\n<key>\n" + string(keyData) + "</key>\n"
+	cfg, err := ParseConfig(strings.NewReader(conf))
+	if err != nil {
+		t.Fatal("Parse config", err)
+	}
+	if findings := LintConfig(cfg, dir); len(findings) != 0 {
+		t.Error("Unexpected findings", findings)
+	}
+}
@@ -0,0 +1,59 @@
+package vpnc
+import (
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// In-process locks by lock file. Flock doesn't exclude goroutines of the same process
+var (
+	locksGuard sync.Mutex
+	locks      = make(map[string]*sync.Mutex)
+)
+
+// Acquire exclusive lock: in-process mutex and flock of lock file across processes. Returns unlock function
+func lockFile(file string) (func(), error) {
+	file, err := filepath.Abs(file)
+	if err != nil {
+		return nil, err
+	}
+	locksGuard.Lock()
+	mu, ok := locks[file]
+	if !ok {
+		mu = &sync.Mutex{}
+		locks[file] = mu
+	}
+	locksGuard.Unlock()
+
+	mu.Lock()
+	if err = os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		mu.Unlock()
+		return nil, err
+	}
+	f, err := os.OpenFile(file, os.O_CREATE | os.O_RDWR, 0600)
+	if err != nil {
+		mu.Unlock()
+		return nil, err
+	}
+	if err = flock(f); err != nil {
+		f.Close()
+		mu.Unlock()
+		return nil, err
+	}
+	return func() {
+		funlock(f)
+		f.Close()
+		mu.Unlock()
+	}, nil
+}
+
+// Lock keys directory for modification (keys.lock near keys directory: clean-all removes whole directory).
+// Mutating methods of EasyRSA lock it themselves
+func (er EasyRSA) Lock() (func(), error) {
+	return lockFile(er.KeysDir() + ".lock")
+}
+
+// Lock PersistIPFile for modification. AddStaticIP and RemoveStaticIP lock it themselves
+func (ovpn OpenVPNServer) lockStaticIP() (func(), error) {
+	return lockFile(ovpn.PersistIPFile + ".lock")
+}
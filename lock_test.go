@@ -0,0 +1,83 @@
+package vpnc
+import (
+	"testing"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+	"sync"
+)
+
+func TestLockFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	counter := 0
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			unlock, err := lockFile(path.Join(dir, "test.lock"))
+			if err != nil {
+				t.Error("Lock", err)
+				return
+			}
+			defer unlock()
+			value := counter
+			ioutil.ReadDir(dir) // yield
+			counter = value + 1
+		}()
+	}
+	wg.Wait()
+	if counter != 20 {
+		t.Error("Lock is not exclusive", counter)
+	}
+}
+
+func TestConcurrentStaticIP(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ovpn := OpenVPNServer{PersistIPFile: path.Join(dir, "ipp.txt")}
+	initial := ""
+	for i := 0; i < 10; i++ {
+		initial += "old" + strconv.Itoa(i) + ",10.8.1." + strconv.Itoa(i) + "\n"
+	}
+	if err = ioutil.WriteFile(ovpn.PersistIPFile, []byte(initial), 0600); err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			if err := ovpn.AddStaticIP("new" + strconv.Itoa(i), "10.8.0." + strconv.Itoa(100 + i)); err != nil {
+				t.Error("Add static IP", err)
+			}
+		}(i)
+		go func(i int) {
+			defer wg.Done()
+			if err := ovpn.RemoveStaticIP("old" + strconv.Itoa(i)); err != nil {
+				t.Error("Remove static IP", err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	ips, err := ovpn.ListStaticIP()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ips) != 10 {
+		t.Error("Lost updates", ips)
+	}
+	for i := 0; i < 10; i++ {
+		if ips["new" + strconv.Itoa(i)] != "10.8.0." + strconv.Itoa(100 + i) {
+			t.Error("Missing client new" + strconv.Itoa(i))
+		}
+	}
+}
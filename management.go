@@ -0,0 +1,460 @@
+package vpnc
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Size of buffer for real-time events. Events are dropped if nobody reads them
+const ManagementEventsBuffer = 128
+
+// Default timeout of management commands (see ManagementClient.Timeout)
+const ManagementTimeout = 10 * time.Second
+
+// Error returned by management interface (ERROR: ...)
+type ManagementError struct {
+	Message string
+}
+
+func (me *ManagementError) Error() string {
+	return "management: " + me.Message
+}
+
+// Real-time notification from management interface (lines started by >)
+type ManagementEvent struct {
+	Type string            // Notification type: BYTECOUNT_CLI, STATE, CLIENT, INFO, LOG and e.t.c.
+	Data string            // Raw data after type
+	Env  map[string]string // Client environment for CLIENT notifications (CONNECT, REAUTH, ESTABLISHED, DISCONNECT)
+}
+
+// Traffic counters of client (BYTECOUNT_CLI notification)
+type ManagementByteCount struct {
+	ClientID uint64
+	BytesIn  uint64 // Bytes received by server from client
+	BytesOut uint64 // Bytes sent by server to client
+}
+
+// Server state change (STATE notification)
+type ManagementState struct {
+	Time        time.Time
+	State       string // CONNECTING, WAIT, AUTH, GET_CONFIG, ASSIGN_IP, ADD_ROUTES, CONNECTED, RECONNECTING, EXITING
+	Description string
+	LocalIP     string
+	RemoteIP    string
+}
+
+// Client notification (CLIENT:CONNECT, CLIENT:DISCONNECT and e.t.c.)
+type ManagementClientEvent struct {
+	Kind     string // CONNECT, REAUTH, ESTABLISHED, DISCONNECT or ADDRESS
+	ClientID uint64
+	KeyID    uint64
+	Env      map[string]string // Client environment: common_name, trusted_ip, bytes_received and e.t.c.
+}
+
+// Parse BYTECOUNT_CLI notification
+func (me ManagementEvent) ByteCount() (ManagementByteCount, bool) {
+	var bc ManagementByteCount
+	fields := strings.Split(me.Data, ",")
+	if me.Type != "BYTECOUNT_CLI" || len(fields) != 3 {
+		return bc, false
+	}
+	var err [3]error
+	bc.ClientID, err[0] = strconv.ParseUint(fields[0], 10, 64)
+	bc.BytesIn, err[1] = strconv.ParseUint(fields[1], 10, 64)
+	bc.BytesOut, err[2] = strconv.ParseUint(fields[2], 10, 64)
+	return bc, err[0] == nil && err[1] == nil && err[2] == nil
+}
+
+// Parse STATE notification
+func (me ManagementEvent) State() (ManagementState, bool) {
+	if me.Type != "STATE" {
+		return ManagementState{}, false
+	}
+	return parseManagementState(me.Data)
+}
+
+// Parse CLIENT notification
+func (me ManagementEvent) Client() (ManagementClientEvent, bool) {
+	var ce ManagementClientEvent
+	fields := strings.Split(me.Data, ",")
+	if me.Type != "CLIENT" || len(fields) < 2 {
+		return ce, false
+	}
+	ce.Kind = fields[0]
+	ce.Env = me.Env
+	ce.ClientID, _ = strconv.ParseUint(fields[1], 10, 64)
+	if len(fields) > 2 {
+		ce.KeyID, _ = strconv.ParseUint(fields[2], 10, 64)
+	}
+	return ce, true
+}
+
+// Client for OpenVPN management interface (see management directive)
+type ManagementClient struct {
+	Timeout time.Duration // Timeout of commands without context. Optional (ManagementTimeout)
+
+	conn        net.Conn
+	reader      *bufio.Reader
+	lock        sync.Mutex
+	pendingLock sync.Mutex
+	pending     *managementCommand
+	events      chan ManagementEvent
+	done        chan struct{}
+	closing     chan struct{}
+	closeOnce   sync.Once
+	closeErr    error
+	err         error
+}
+
+// Command waiting for response lines
+type managementCommand struct {
+	responses chan string
+	finished  chan struct{}
+}
+
+// Connect to management interface of server (ManagementAddr)
+func (ovpn OpenVPNServer) DialManagement(password string) (*ManagementClient, error) {
+	if ovpn.ManagementAddr == "" {
+		return nil, &FieldError{Field: "ManagementAddr", Message: "Management interface is not configured"}
+	}
+	network := "tcp"
+	if ovpn.ManagementUnix() {
+		network = "unix"
+	}
+	return DialManagement(network, ovpn.ManagementAddr, password)
+}
+
+// Connect to management interface by network (tcp or unix) and address. Password is optional
+func DialManagement(network, address, password string) (*ManagementClient, error) {
+	conn, err := net.Dial(network, address)
+	if err != nil {
+		return nil, err
+	}
+	mc, err := NewManagementClient(conn, password)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return mc, nil
+}
+
+// Create management client over established connection. Password is optional
+func NewManagementClient(conn net.Conn, password string) (*ManagementClient, error) {
+	mc := &ManagementClient{
+		conn:      conn,
+		reader:    bufio.NewReader(conn),
+		events:    make(chan ManagementEvent, ManagementEventsBuffer),
+		done:      make(chan struct{}),
+		closing:   make(chan struct{}),
+	}
+	if password != "" {
+		if err := mc.authenticate(password); err != nil {
+			return nil, err
+		}
+	}
+	go mc.readLoop()
+	return mc, nil
+}
+
+func (mc *ManagementClient) authenticate(password string) error {
+	// prompt is not terminated by new line
+	const prompt = "ENTER PASSWORD:"
+	var received []byte
+	for !strings.HasSuffix(string(received), prompt) {
+		c, err := mc.reader.ReadByte()
+		if err != nil {
+			return err
+		}
+		received = append(received, c)
+	}
+	if _, err := io.WriteString(mc.conn, password + "\n"); err != nil {
+		return err
+	}
+	line, err := mc.reader.ReadString('\n')
+	if err != nil {
+		return err
+	}
+	line = strings.TrimSpace(line)
+	if !strings.HasPrefix(line, "SUCCESS:") {
+		return &ManagementError{Message: strings.TrimSpace(strings.TrimPrefix(line, "ERROR:"))}
+	}
+	return nil
+}
+
+// Real-time notifications. Channel is closed when connection is closed
+func (mc *ManagementClient) Events() <-chan ManagementEvent {
+	return mc.events
+}
+
+// Close connection
+func (mc *ManagementClient) Close() error {
+	mc.closeConn()
+	<-mc.done
+	return mc.closeErr
+}
+
+func (mc *ManagementClient) closeConn() {
+	mc.closeOnce.Do(func() {
+		close(mc.closing)
+		mc.closeErr = mc.conn.Close()
+	})
+}
+
+// Send command and read single line response (SUCCESS: ...). Returns text after SUCCESS:. Fails after Timeout
+func (mc *ManagementClient) Command(cmd string) (string, error) {
+	ctx, cancel := mc.timeoutContext()
+	defer cancel()
+	return mc.CommandContext(ctx, cmd)
+}
+
+// Same as Command but waits for response until context is done. Connection is closed if command is not
+// answered in time: late response would be taken as reply to next command
+func (mc *ManagementClient) CommandContext(ctx context.Context, cmd string) (string, error) {
+	mc.lock.Lock()
+	defer mc.lock.Unlock()
+	pc, err := mc.start(ctx, cmd)
+	if err != nil {
+		return "", err
+	}
+	defer mc.finish(pc)
+	line, err := mc.receive(ctx, pc)
+	if err != nil {
+		return "", err
+	}
+	if strings.HasPrefix(line, "ERROR:") {
+		return "", &ManagementError{Message: strings.TrimSpace(strings.TrimPrefix(line, "ERROR:"))}
+	}
+	return strings.TrimSpace(strings.TrimPrefix(line, "SUCCESS:")), nil
+}
+
+// Send command and read multi-line response terminated by END. Fails after Timeout
+func (mc *ManagementClient) CommandLines(cmd string) ([]string, error) {
+	ctx, cancel := mc.timeoutContext()
+	defer cancel()
+	return mc.CommandLinesContext(ctx, cmd)
+}
+
+// Same as CommandLines but waits for response until context is done (see CommandContext)
+func (mc *ManagementClient) CommandLinesContext(ctx context.Context, cmd string) ([]string, error) {
+	mc.lock.Lock()
+	defer mc.lock.Unlock()
+	pc, err := mc.start(ctx, cmd)
+	if err != nil {
+		return nil, err
+	}
+	defer mc.finish(pc)
+	var lines []string
+	for {
+		line, err := mc.receive(ctx, pc)
+		if err != nil {
+			return nil, err
+		}
+		if len(lines) == 0 && strings.HasPrefix(line, "ERROR:") {
+			return nil, &ManagementError{Message: strings.TrimSpace(strings.TrimPrefix(line, "ERROR:"))}
+		}
+		if line == "END" {
+			return lines, nil
+		}
+		lines = append(lines, line)
+	}
+}
+
+// Version of OpenVPN and management interface
+func (mc *ManagementClient) Version() ([]string, error) {
+	return mc.CommandLines("version")
+}
+
+// Current status: connected clients and routing table
+func (mc *ManagementClient) Status() (*Status, error) {
+	lines, err := mc.CommandLines("status 2")
+	if err != nil {
+		return nil, err
+	}
+	return ParseStatus(strings.NewReader(strings.Join(lines, "\n")))
+}
+
+// Kill all sessions of client by common name
+func (mc *ManagementClient) Kill(commonName string) error {
+	if commonName == "" || strings.ContainsAny(commonName, "\r\n") {
+		return errors.New("Invalid common name " + strconv.Quote(commonName))
+	}
+	_, err := mc.Command("kill " + quoteArg(commonName))
+	return err
+}
+
+// Kill client session by client ID (from CLIENT notifications)
+func (mc *ManagementClient) KillClient(clientID uint64) error {
+	_, err := mc.Command("client-kill " + strconv.FormatUint(clientID, 10))
+	return err
+}
+
+// Enable (interval in seconds) or disable (zero interval) BYTECOUNT_CLI notifications
+func (mc *ManagementClient) ByteCount(interval int) error {
+	_, err := mc.Command("bytecount " + strconv.Itoa(interval))
+	return err
+}
+
+// Enable or disable STATE notifications
+func (mc *ManagementClient) StateNotifications(enable bool) error {
+	cmd := "state off"
+	if enable {
+		cmd = "state on"
+	}
+	_, err := mc.Command(cmd)
+	return err
+}
+
+// Current state of server
+func (mc *ManagementClient) State() (ManagementState, error) {
+	lines, err := mc.CommandLines("state")
+	if err != nil {
+		return ManagementState{}, err
+	}
+	if len(lines) == 0 {
+		return ManagementState{}, errors.New("Empty state")
+	}
+	state, ok := parseManagementState(lines[len(lines) - 1])
+	if !ok {
+		return state, errors.New("Bad state " + lines[len(lines) - 1])
+	}
+	return state, nil
+}
+
+func (mc *ManagementClient) timeoutContext() (context.Context, context.CancelFunc) {
+	timeout := mc.Timeout
+	if timeout <= 0 {
+		timeout = ManagementTimeout
+	}
+	return context.WithTimeout(context.Background(), timeout)
+}
+
+// Register pending command and send it
+func (mc *ManagementClient) start(ctx context.Context, cmd string) (*managementCommand, error) {
+	pc := &managementCommand{responses: make(chan string), finished: make(chan struct{})}
+	mc.pendingLock.Lock()
+	mc.pending = pc
+	mc.pendingLock.Unlock()
+	if deadline, ok := ctx.Deadline(); ok {
+		mc.conn.SetWriteDeadline(deadline)
+		defer mc.conn.SetWriteDeadline(time.Time{})
+	}
+	if _, err := io.WriteString(mc.conn, cmd + "\n"); err != nil {
+		mc.finish(pc)
+		return nil, err
+	}
+	return pc, nil
+}
+
+func (mc *ManagementClient) finish(pc *managementCommand) {
+	mc.pendingLock.Lock()
+	if mc.pending == pc {
+		mc.pending = nil
+	}
+	mc.pendingLock.Unlock()
+	close(pc.finished)
+}
+
+func (mc *ManagementClient) receive(ctx context.Context, pc *managementCommand) (string, error) {
+	select {
+	case line := <-pc.responses:
+		return line, nil
+	case <-mc.done:
+		if mc.err != nil {
+			return "", mc.err
+		}
+		return "", io.EOF
+	case <-ctx.Done():
+		mc.closeConn()
+		return "", ctx.Err()
+	}
+}
+
+func (mc *ManagementClient) readLoop() {
+	defer close(mc.done)
+	defer close(mc.events)
+	var client *ManagementEvent
+	for {
+		line, err := mc.reader.ReadString('\n')
+		if err != nil {
+			if err != io.EOF {
+				mc.err = err
+			}
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if !strings.HasPrefix(line, ">") {
+			mc.pendingLock.Lock()
+			pc := mc.pending
+			mc.pendingLock.Unlock()
+			// unsolicited line is not a reply to next command
+			if pc == nil {
+				continue
+			}
+			select {
+			case pc.responses <- line:
+			case <-pc.finished:
+			case <-mc.closing:
+				return
+			}
+			continue
+		}
+		kv := strings.SplitN(line[1:], ":", 2)
+		event := ManagementEvent{Type: kv[0]}
+		if len(kv) > 1 {
+			event.Data = kv[1]
+		}
+		if event.Type == "CLIENT" {
+			switch {
+			case event.Data == "ENV,END" && client != nil:
+				mc.emit(*client)
+				client = nil
+			case strings.HasPrefix(event.Data, "ENV,") && client != nil:
+				env := strings.SplitN(strings.TrimPrefix(event.Data, "ENV,"), "=", 2)
+				if len(env) == 2 {
+					client.Env[env[0]] = env[1]
+				}
+			case strings.HasPrefix(event.Data, "ADDRESS,"):
+				mc.emit(event)
+			default:
+				event.Env = make(map[string]string)
+				client = &event
+			}
+			continue
+		}
+		mc.emit(event)
+	}
+}
+
+func (mc *ManagementClient) emit(event ManagementEvent) {
+	select {
+	case mc.events <- event:
+	default:
+	}
+}
+
+func parseManagementState(data string) (ManagementState, bool) {
+	fields := strings.Split(data, ",")
+	if len(fields) < 2 {
+		return ManagementState{}, false
+	}
+	state := ManagementState{State: fields[1]}
+	if sec, err := strconv.ParseInt(fields[0], 10, 64); err == nil {
+		state.Time = time.Unix(sec, 0)
+	}
+	if len(fields) > 2 {
+		state.Description = fields[2]
+	}
+	if len(fields) > 3 {
+		state.LocalIP = fields[3]
+	}
+	if len(fields) > 4 {
+		state.RemoteIP = fields[4]
+	}
+	return state, true
+}
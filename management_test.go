@@ -0,0 +1,209 @@
+package vpnc
+import (
+	"testing"
+	"bufio"
+	"context"
+	"io/ioutil"
+	"net"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// Fake management interface: serves single connection
+func serveFakeManagement(t *testing.T, listener net.Listener, password string) {
+	conn, err := listener.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	write := func(lines ...string) {
+		for _, line := range lines {
+			conn.Write([]byte(line + "\r\n"))
+		}
+	}
+	if password != "" {
+		conn.Write([]byte("ENTER PASSWORD:"))
+		line, _ := reader.ReadString('\n')
+		if strings.TrimSpace(line) != password {
+			write("ERROR: bad password")
+			return
+		}
+		write("SUCCESS: password is correct")
+	}
+	write(">INFO:OpenVPN Management Interface Version 1 -- type 'help' for more info")
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		switch cmd := strings.TrimSpace(line); cmd {
+		case "version":
+			write("OpenVPN Version: OpenVPN 2.4.4", "Management Version: 1", "END")
+		case "status 2":
+			write(strings.Split(strings.TrimSpace(testStatusV2), "\n")...)
+		case "kill ivan":
+			write("SUCCESS: common name 'ivan' found, 1 client(s) killed")
+		case "kill petr":
+			write("ERROR: common name 'petr' not found")
+		case `kill "ivan petrov"`:
+			write("SUCCESS: common name 'ivan petrov' found, 1 client(s) killed")
+		case "stale":
+			write("SUCCESS: ok", "SUCCESS: stale", ">INFO:stale sent")
+		case "hang":
+		case "bytecount 5":
+			write("SUCCESS: bytecount interval changed", ">BYTECOUNT_CLI:3,100,200")
+			write(">CLIENT:CONNECT,4,1", ">CLIENT:ENV,common_name=petr", ">CLIENT:ENV,trusted_ip=1.2.3.4", ">CLIENT:ENV,END")
+		case "state on":
+			write("SUCCESS: real-time state notification set to ON", ">STATE:1434615135,CONNECTED,SUCCESS,10.8.0.1,")
+		default:
+			write("ERROR: unknown command, enter 'help' for more options")
+		}
+	}
+}
+
+func checkManagementClient(t *testing.T, mc *ManagementClient) {
+	version, err := mc.Version()
+	if err != nil || len(version) != 2 {
+		t.Error("Get version", version, err)
+	}
+	st, err := mc.Status()
+	if err != nil {
+		t.Fatal("Get status", err)
+	}
+	if st.Client("ivan") == nil {
+		t.Error("Client not found in status")
+	}
+	if err = mc.Kill("ivan"); err != nil {
+		t.Error("Kill client", err)
+	}
+	err = mc.Kill("petr")
+	if _, ok := err.(*ManagementError); !ok {
+		t.Error("Kill unknown client must return management error", err)
+	}
+	if err = mc.ByteCount(5); err != nil {
+		t.Fatal("Enable bytecount", err)
+	}
+	if err = mc.StateNotifications(true); err != nil {
+		t.Fatal("Enable state", err)
+	}
+	var byteCount, client, state bool
+	timeout := time.After(5 * time.Second)
+	for !(byteCount && client && state) {
+		select {
+		case event := <-mc.Events():
+			if bc, ok := event.ByteCount(); ok {
+				byteCount = bc.ClientID == 3 && bc.BytesIn == 100 && bc.BytesOut == 200
+			}
+			if ce, ok := event.Client(); ok {
+				client = ce.Kind == "CONNECT" && ce.ClientID == 4 && ce.Env["common_name"] == "petr"
+			}
+			if s, ok := event.State(); ok {
+				state = s.State == "CONNECTED" && s.LocalIP == "10.8.0.1"
+			}
+		case <-timeout:
+			t.Fatal("Events not received", byteCount, client, state)
+		}
+	}
+}
+
+func TestManagementTCP(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go serveFakeManagement(t, listener, "secret")
+	ovpn := OpenVPNServer{ManagementAddr: listener.Addr().String()}
+	if ovpn.ManagementUnix() {
+		t.Error("TCP address detected as unix socket")
+	}
+	mc, err := ovpn.DialManagement("secret")
+	if err != nil {
+		t.Fatal("Connect to management", err)
+	}
+	defer mc.Close()
+	checkManagementClient(t, mc)
+}
+
+func TestManagementUnix(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mgmt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	socket := path.Join(dir, "mgmt.sock")
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go serveFakeManagement(t, listener, "")
+	ovpn := OpenVPNServer{ManagementAddr: socket}
+	if ovpn.ManagementArgs() != socket + " unix" {
+		t.Error("Bad management directive", ovpn.ManagementArgs())
+	}
+	mc, err := ovpn.DialManagement("")
+	if err != nil {
+		t.Fatal("Connect to management", err)
+	}
+	defer mc.Close()
+	checkManagementClient(t, mc)
+}
+
+func TestManagementBadPassword(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go serveFakeManagement(t, listener, "secret")
+	if _, err = DialManagement("tcp", listener.Addr().String(), "wrong"); err == nil {
+		t.Error("Bad password accepted")
+	}
+}
+
+func TestManagementStaleAndTimeout(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go serveFakeManagement(t, listener, "")
+	mc, err := DialManagement("tcp", listener.Addr().String(), "")
+	if err != nil {
+		t.Fatal("Connect to management", err)
+	}
+	defer mc.Close()
+	if err = mc.Kill("ivan petrov"); err != nil {
+		t.Error("Kill client with space in name", err)
+	}
+	if err = mc.Kill("ivan\nsignal SIGTERM"); err == nil {
+		t.Error("Common name with new line must be rejected")
+	}
+	if reply, err := mc.Command("stale"); err != nil || reply != "ok" {
+		t.Fatal("Command with extra response line", reply, err)
+	}
+	// extra line is read before event sent after it
+	timeout := time.After(5 * time.Second)
+	for sent := false; !sent; {
+		select {
+		case event := <-mc.Events():
+			sent = event.Type == "INFO" && event.Data == "stale sent"
+		case <-timeout:
+			t.Fatal("Event not received")
+		}
+	}
+	if version, err := mc.Version(); err != nil || len(version) != 2 || version[0] != "OpenVPN Version: OpenVPN 2.4.4" {
+		t.Error("Unsolicited line taken as reply", version, err)
+	}
+	mc.Timeout = 100 * time.Millisecond
+	if _, err = mc.Command("hang"); err != context.DeadlineExceeded {
+		t.Error("Not answered command must time out", err)
+	}
+	if _, err = mc.Version(); err == nil {
+		t.Error("Connection must be closed after timeout")
+	}
+}
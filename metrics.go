@@ -0,0 +1,190 @@
+package vpnc
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Prometheus exporter of VPN server metrics: connected clients and traffic from status file,
+// certificates expiration from easy-rsa index and CRL age
+type Exporter struct {
+	Server  OpenVPNServer // Server with status file (see StatusPath)
+	RSA     *EasyRSA      // Easy-rsa for certificates inventory. Optional
+	CRLFile string        // Location of certificate revocation list. Optional (crl.pem in keys dir)
+}
+
+// Location of certificate revocation list
+func (exp Exporter) CRL() string {
+	if exp.CRLFile != "" || exp.RSA == nil {
+		return exp.CRLFile
+	}
+	return exp.RSA.CRLFile()
+}
+
+// Serve metrics in Prometheus text format
+func (exp Exporter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	buf := &bytes.Buffer{}
+	if err := exp.WriteMetrics(buf); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.Write(buf.Bytes())
+}
+
+// Collect and write metrics in Prometheus text format. Unavailable sources are reported by *_up metrics
+func (exp Exporter) WriteMetrics(w io.Writer) error {
+	mw := &metricsWriter{}
+	exp.statusMetrics(mw)
+	if exp.RSA != nil {
+		exp.certificateMetrics(mw)
+	}
+	if crl := exp.CRL(); crl != "" {
+		exp.crlMetrics(mw, crl)
+	}
+	_, err := w.Write(mw.buf.Bytes())
+	return err
+}
+
+func (exp Exporter) statusMetrics(mw *metricsWriter) {
+	st, err := exp.Server.ReadStatus()
+	mw.family("openvpn_status_up", "gauge", "Status file is readable and parsed")
+	if err != nil {
+		mw.sample("openvpn_status_up", nil, 0)
+		return
+	}
+	mw.sample("openvpn_status_up", nil, 1)
+	mw.family("openvpn_status_update_time_seconds", "gauge", "Time of last status file update")
+	mw.sample("openvpn_status_update_time_seconds", nil, unixTime(st.Updated))
+	mw.family("openvpn_connected_clients", "gauge", "Number of connected clients")
+	mw.sample("openvpn_connected_clients", nil, float64(len(st.Clients)))
+
+	mw.family("openvpn_client_received_bytes_total", "counter", "Bytes received by server from client")
+	for _, c := range st.Clients {
+		mw.sample("openvpn_client_received_bytes_total", clientLabels(c), float64(c.BytesReceived))
+	}
+	mw.family("openvpn_client_sent_bytes_total", "counter", "Bytes sent by server to client")
+	for _, c := range st.Clients {
+		mw.sample("openvpn_client_sent_bytes_total", clientLabels(c), float64(c.BytesSent))
+	}
+	mw.family("openvpn_client_connected_since_seconds", "gauge", "Time of client connection")
+	for _, c := range st.Clients {
+		mw.sample("openvpn_client_connected_since_seconds", clientLabels(c), unixTime(c.ConnectedSince))
+	}
+}
+
+func (exp Exporter) certificateMetrics(mw *metricsWriter) {
+	entries, err := exp.RSA.Index()
+	mw.family("openvpn_index_up", "gauge", "Certificates index is readable")
+	if err != nil {
+		mw.sample("openvpn_index_up", nil, 0)
+	} else {
+		mw.sample("openvpn_index_up", nil, 1)
+		counts := map[string]int{"valid": 0, "revoked": 0, "expired": 0}
+		mw.family("openvpn_certificate_expiry_time_seconds", "gauge", "Expiration time of issued certificates")
+		for _, e := range entries {
+			status := indexStatus(e)
+			counts[status]++
+			mw.sample("openvpn_certificate_expiry_time_seconds", []string{"common_name", e.CommonName, "serial", e.Serial, "status", status}, unixTime(e.Expires))
+		}
+		mw.family("openvpn_certificates", "gauge", "Number of issued certificates by status")
+		var statuses []string
+		for status := range counts {
+			statuses = append(statuses, status)
+		}
+		sort.Strings(statuses)
+		for _, status := range statuses {
+			mw.sample("openvpn_certificates", []string{"status", status}, float64(counts[status]))
+		}
+	}
+	if data, err := ioutil.ReadFile(exp.RSA.KeyFiles().CA.Certificate); err == nil {
+		if ca, err := parseCertificate(data); err == nil {
+			mw.family("openvpn_ca_expiry_time_seconds", "gauge", "Expiration time of CA certificate")
+			mw.sample("openvpn_ca_expiry_time_seconds", nil, unixTime(ca.NotAfter))
+		}
+	}
+}
+
+func (exp Exporter) crlMetrics(mw *metricsWriter, file string) {
+	mw.family("openvpn_crl_up", "gauge", "Certificate revocation list is readable and parsed")
+	crl, err := readCRL(file)
+	if err != nil {
+		mw.sample("openvpn_crl_up", nil, 0)
+		return
+	}
+	mw.sample("openvpn_crl_up", nil, 1)
+	mw.family("openvpn_crl_update_time_seconds", "gauge", "Time of CRL issue (this update)")
+	mw.sample("openvpn_crl_update_time_seconds", nil, unixTime(crl.ThisUpdate))
+	mw.family("openvpn_crl_next_update_time_seconds", "gauge", "Time of next CRL update")
+	mw.sample("openvpn_crl_next_update_time_seconds", nil, unixTime(crl.NextUpdate))
+	mw.family("openvpn_crl_age_seconds", "gauge", "Age of CRL")
+	mw.sample("openvpn_crl_age_seconds", nil, time.Since(crl.ThisUpdate).Seconds())
+	mw.family("openvpn_crl_revoked_certificates", "gauge", "Number of certificates in CRL")
+	mw.sample("openvpn_crl_revoked_certificates", nil, float64(len(crl.RevokedCertificateEntries)))
+}
+
+// Read CRL in PEM or DER format
+func readCRL(file string) (*x509.RevocationList, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	if block, _ := pem.Decode(data); block != nil {
+		data = block.Bytes
+	}
+	return x509.ParseRevocationList(data)
+}
+
+func indexStatus(e IndexEntry) string {
+	switch {
+	case e.Status == "R":
+		return "revoked"
+	case e.Valid():
+		return "valid"
+	}
+	return "expired"
+}
+
+// Labels of client series. Real address (IP and ephemeral port) is not used: it changes on every reconnect
+func clientLabels(c StatusClient) []string {
+	return []string{"common_name", c.CommonName, "virtual_address", c.VirtualAddress}
+}
+
+func unixTime(t time.Time) float64 {
+	if t.IsZero() {
+		return 0
+	}
+	return float64(t.UnixNano()) / 1e9
+}
+
+// Writer of Prometheus text format
+type metricsWriter struct {
+	buf bytes.Buffer
+}
+
+func (mw *metricsWriter) family(name, kind, help string) {
+	fmt.Fprintf(&mw.buf, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+// Write sample. Labels are pairs of name and value
+func (mw *metricsWriter) sample(name string, labels []string, value float64) {
+	mw.buf.WriteString(name)
+	if len(labels) > 0 {
+		var parts []string
+		for i := 0; i + 1 < len(labels); i += 2 {
+			parts = append(parts, labels[i] + "=\"" + labelEscaper.Replace(labels[i + 1]) + "\"")
+		}
+		mw.buf.WriteString("{" + strings.Join(parts, ",") + "}")
+	}
+	mw.buf.WriteString(" " + strconv.FormatFloat(value, 'f', -1, 64) + "\n")
+}
+
+var labelEscaper = strings.NewReplacer("\\", "\\\\", "\"", "\\\"", "\n", "\\n")
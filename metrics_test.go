@@ -0,0 +1,91 @@
+package vpnc
+import (
+	"testing"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+func TestExporter(t *testing.T) {
+	dir, err := ioutil.TempDir("", "metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	rsa := getInstance()
+	rsa.KeyDir = dir
+	caCert, caKey := writeTestCert(t, dir, "ca", time.Unix(2000000000, 0))
+	index := "V\t20500101000000Z\t\t01\tunknown\t/C=RU/CN=ivan\nR\t20500101000000Z\t160505000000Z\t02\tunknown\t/C=RU/CN=petr\n"
+	if err = ioutil.WriteFile(path.Join(dir, "index.txt"), []byte(index), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// CRL signed by test CA
+	certData, _ := ioutil.ReadFile(caCert)
+	keyData, _ := ioutil.ReadFile(caKey)
+	ca, err := parseCertificate(certData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca.KeyUsage |= x509.KeyUsageCRLSign
+	ca.SubjectKeyId = []byte{1, 2, 3}
+	signer, err := parsePrivateKey(keyData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	crl, err := x509.CreateRevocationList(nil, &x509.RevocationList{
+		Number:     big.NewInt(1),
+		ThisUpdate: time.Now().Add(-time.Hour),
+		NextUpdate: time.Now().Add(time.Hour),
+		RevokedCertificateEntries: []x509.RevocationListEntry{{SerialNumber: big.NewInt(2), RevocationTime: time.Now()}},
+	}, ca, signer)
+	if err != nil {
+		t.Fatal("Create CRL", err)
+	}
+	if err = ioutil.WriteFile(path.Join(dir, "crl.pem"), pem.EncodeToMemory(&pem.Block{Type: "X509 CRL", Bytes: crl}), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err = ioutil.WriteFile(path.Join(dir, "status.log"), []byte(testStatusV2), 0644); err != nil {
+		t.Fatal(err)
+	}
+	exp := Exporter{Server: OpenVPNServer{StatusFile: path.Join(dir, "status.log")}, RSA: &rsa}
+	rec := httptest.NewRecorder()
+	exp.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	body := rec.Body.String()
+	for _, line := range []string{
+		"openvpn_status_up 1",
+		"openvpn_connected_clients 1",
+		`openvpn_client_received_bytes_total{common_name="ivan",virtual_address="10.8.0.6"} 1234`,
+		`openvpn_client_sent_bytes_total{common_name="ivan",virtual_address="10.8.0.6"} 5678`,
+		`openvpn_certificate_expiry_time_seconds{common_name="ivan",serial="01",status="valid"} 2524608000`,
+		`openvpn_certificates{status="revoked"} 1`,
+		"openvpn_ca_expiry_time_seconds 2000000000",
+		"openvpn_crl_up 1",
+		"openvpn_crl_revoked_certificates 1",
+		"# TYPE openvpn_crl_age_seconds gauge",
+	} {
+		if !strings.Contains(body, line + "\n") {
+			t.Error("No metric", line)
+		}
+	}
+	if strings.Contains(body, "real_address") {
+		t.Error("Real address must not be a label")
+	}
+	if !strings.HasPrefix(rec.Header().Get("Content-Type"), "text/plain") {
+		t.Error("Bad content type", rec.Header().Get("Content-Type"))
+	}
+}
+
+func TestExporterNoSources(t *testing.T) {
+	exp := Exporter{Server: OpenVPNServer{StatusFile: "/nonexistent/status.log"}, CRLFile: "/nonexistent/crl.pem"}
+	rec := httptest.NewRecorder()
+	exp.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if !strings.Contains(rec.Body.String(), "openvpn_status_up 0\n") || !strings.Contains(rec.Body.String(), "openvpn_crl_up 0\n") {
+		t.Error("Unavailable sources must be reported", rec.Body.String())
+	}
+}
@@ -9,6 +9,10 @@ import (
 	"io/ioutil"
 	"strings"
 	"strconv"
+	"io"
+	"bytes"
+	"encoding/pem"
+	"net"
 )
 
 const vpnConf = `{{with .LocalAddr}}local {{.}}{{end}}
@@ -19,33 +23,52 @@ ca   {{.Keys.CA.Certificate}}
 cert {{.Keys.Server.Certificate}}
 key  {{.Keys.Server.Key}}
 dh   {{.Keys.DiffieHellman}}
-server 10.8.0.0 255.255.255.0
-{{with .PersistIPFile}}ifconfig-pool-persist {{.}}{{end}}
-{{if .ClientToClient}}client-to-client{{end}}
+server {{.ServerArgs}}
+{{with .NetworkIPv6}}server-ipv6 {{.}}
+{{end}}{{with .PersistIPFile}}ifconfig-pool-persist {{.}}{{end}}
+{{with .ClientConfigDir}}client-config-dir {{.}}
+{{end}}{{if .ClientToClient}}client-to-client{{end}}
 keepalive 10 120
-{{with .TlsKey}}tls-auth {{.}} 0{{end}}
-comp-lzo
+{{with .TlsKey}}{{if $.TlsCrypt}}tls-crypt {{.}}{{else}}tls-auth {{.}} 0{{end}}{{end}}
+{{with .CRLFile}}crl-verify {{.}}
+{{end}}comp-lzo
 persist-key
 persist-tun
-status openvpn-status.log
-verb 3
-`
+status {{.StatusLog}}
+{{with .StatusVersion}}status-version {{.}}
+{{end}}{{if .ManagementAddr}}management {{.ManagementArgs}}
+{{end}}verb 3
+{{with .ExtraServerDirectives}}
+# Extra directives
+{{range .}}{{.}}
+{{end}}{{end}}`
 
 const clientConf = `client
 dev tun
 proto {{.Protocol}}
 
-{{range .Addresses}}
-remote {{.}} {{$.Port}}{{end}}
+{{range .ClientRemotes}}
+remote {{.}}{{end}}{{if .RemoteRandom}}
+remote-random{{end}}{{with .ConnectRetry}}
+connect-retry {{.}}{{with $.ConnectRetryMax}} {{.}}{{end}}{{end}}{{with .ServerPollTimeout}}
+server-poll-timeout {{.}}{{end}}
 
 resolv-retry infinite
 
-ca {{.BaseCACertFile}}
+{{if .Inline}}<ca>
+{{.InlineCA}}</ca>
+<cert>
+{{.InlineCert}}</cert>
+<key>
+{{.InlineKey}}</key>{{else}}ca {{.BaseCACertFile}}
 cert {{.ClientCertFile}}
-key {{.ClientKeyFile}}
+key {{.ClientKeyFile}}{{end}}
 {{if .TlsKey}}
 tls-client
-tls-auth {{.BaseTLSKeyFile}} 1
+{{if .Inline}}{{if .TlsCrypt}}<tls-crypt>
+{{.InlineTLSKey}}</tls-crypt>{{else}}key-direction 1
+<tls-auth>
+{{.InlineTLSKey}}</tls-auth>{{end}}{{else}}{{if .TlsCrypt}}tls-crypt {{.BaseTLSKeyFile}}{{else}}tls-auth {{.BaseTLSKeyFile}} 1{{end}}{{end}}
 auth SHA1
 cipher BF-CBC
 remote-cert-tls server
@@ -53,11 +76,27 @@ remote-cert-tls server
 comp-lzo
 persist-key
 persist-tun
-
-status openvpn-status.log
+{{with .ClientPlatform.DNSScript}}
+script-security 2
+up {{.}}
+down {{.}}
+{{if $.ClientPlatform.DownPre}}down-pre
+{{end}}{{end}}{{if .ClientPlatform.BlockOutsideDNS}}block-outside-dns
+{{end}}
+{{if not .ClientPlatform}}status openvpn-status.log
 log /var/log/openvpn.log
-verb 3
-mute 20`
+{{end}}verb 3
+mute 20{{with .ExtraClientDirectives}}
+
+# Extra directives
+{{range .}}{{.}}
+{{end}}{{end}}`
+
+// Default template of server configuration. Could be used as base for OpenVPNServer.ServerTemplate
+const DefaultServerTemplate = vpnConf
+
+// Default template of client configuration. Could be used as base for OpenVPNServer.ClientTemplate
+const DefaultClientTemplate = clientConf
 
 type OpenVPNServer struct {
 	LocalAddr      string   // Bind to specific local address. Optional
@@ -68,6 +107,78 @@ type OpenVPNServer struct {
 	PersistIPFile  string   // List of clients and their static ips. Optional
 	TlsKey         string   // Location of TLS key. Automatically sets after BuildTLSKey(). If set, server and clients config will use TLS
 	ClientToClient bool     // Enable client to client communication
+	TlsCrypt       bool     // Use TlsKey as tls-crypt key instead of tls-auth
+	CRLFile        string   // Certificate revocation list (see EasyRSA.CRLFile). If set, revoked clients are rejected
+	ConfigDir      string   // Directory of server configuration (working directory of OpenVPN). Set by OpenServerConf. Only StatusPath uses it
+	ClientPlatform Platform // Target platform of client configuration. Optional (legacy Linux client.conf)
+	StatusFile     string   // Location of status file. Optional (openvpn-status.log)
+	StatusVersion  int      // Format of status file: 1, 2 or 3. Optional (OpenVPN default is 1)
+
+	ManagementAddr         string // Management interface address: host:port or path to unix socket. Optional
+	ManagementPasswordFile string // File with management interface password. Optional
+	ClientConfigDir        string // Directory with per-client configurations (CCD). Optional
+	Network                string // VPN subnet (CIDR) for clients. Optional (DefaultNetwork)
+	NetworkIPv6            string // IPv6 VPN subnet (CIDR) for clients (server-ipv6). Optional
+	networkUnknown         bool   // Read by ServerFromConfig without server directive: Pool does not assume DefaultNetwork
+
+	Remotes           []Remote // Additional remotes of client configuration listed after Addresses (see ServerGroup). Optional
+	RemoteRandom      bool     // Client picks random remote instead of trying them in order
+	ConnectRetry      int      // Seconds between client connection attempts (connect-retry). Optional (OpenVPN default is 5)
+	ConnectRetryMax   int      // Maximum seconds between attempts with backoff (connect-retry second argument). Optional
+	ServerPollTimeout int      // Seconds to wait for server response before trying next remote. Optional (OpenVPN default is 120)
+
+	ExtraServerDirectives []string           // Raw directives appended to server configuration. Optional
+	ExtraClientDirectives []string           // Raw directives appended to client configuration. Optional
+	ServerTemplate        *template.Template // Custom template of server configuration (data is OpenVPNServer). Optional
+	ClientTemplate        *template.Template // Custom template of client configuration (data is ClientTemplateData). Optional
+}
+
+// Data of client configuration template. All fields and methods of OpenVPNServer are accessible
+// directly: {{.Port}}, {{.BaseCACertFile}} and e.t.c.
+type ClientTemplateData struct {
+	OpenVPNServer
+	ClientName     string // Client name (base name of certificate)
+	ClientCertFile string // Base name of client certificate
+	ClientKeyFile  string // Base name of client key
+	Inline         bool   // Embed keys into configuration instead of file references
+	InlineCA       string // Content of CA certificate (only for inline)
+	InlineCert     string // Content of client certificate (only for inline)
+	InlineKey      string // Content of client key (only for inline)
+	InlineTLSKey   string // Content of TLS key (only for inline)
+}
+
+// Server endpoint in client configuration: remote host port proto
+type Remote struct {
+	Host     string `json:"host"`
+	Port     uint16 `json:"port,omitempty"`  // Optional (OpenVPNServer.Port)
+	Protocol string `json:"proto,omitempty"` // udp, udp4, udp6, tcp, tcp4, tcp6 or tcp-client. Optional (OpenVPNServer.Protocol)
+}
+
+// Arguments of remote directive
+func (r Remote) String() string {
+	args := r.Host
+	if r.Port != 0 {
+		args += " " + strconv.Itoa(int(r.Port))
+	}
+	if r.Protocol != "" {
+		args += " " + r.Protocol
+	}
+	return args
+}
+
+// Remotes of client configuration: Addresses with Port followed by Remotes. Not set port of remote is Port
+func (ovpn OpenVPNServer) ClientRemotes() []Remote {
+	var remotes []Remote
+	for _, host := range ovpn.Addresses {
+		remotes = append(remotes, Remote{Host: host, Port: ovpn.Port})
+	}
+	for _, remote := range ovpn.Remotes {
+		if remote.Port == 0 {
+			remote.Port = ovpn.Port
+		}
+		remotes = append(remotes, remote)
+	}
+	return remotes
 }
 
 // Base file name of TLS key
@@ -75,77 +186,162 @@ func (ovpn OpenVPNServer) BaseTLSKeyFile() string {
 	return path.Base(ovpn.TlsKey)
 }
 
+// VPN subnet when Network is not set
+const DefaultNetwork = "10.8.0.0/24"
+
+// IPv4 VPN subnet: Network or DefaultNetwork. Server read from configuration without server directive has
+// unknown subnet (error)
+func (ovpn OpenVPNServer) Pool() (*net.IPNet, error) {
+	network := ovpn.Network
+	if network == "" && ovpn.networkUnknown {
+		return nil, &FieldError{Field: "Network", Message: "VPN network is unknown: configuration has no server directive"}
+	}
+	if network == "" {
+		network = DefaultNetwork
+	}
+	_, pool, err := net.ParseCIDR(network)
+	if err != nil || pool.IP.To4() == nil {
+		return nil, &FieldError{Field: "Network", Message: "Network must be IPv4 subnet like " + DefaultNetwork}
+	}
+	return pool, nil
+}
+
+// IPv6 VPN subnet or nil if NetworkIPv6 is not set
+func (ovpn OpenVPNServer) PoolIPv6() (*net.IPNet, error) {
+	if ovpn.NetworkIPv6 == "" {
+		return nil, nil
+	}
+	_, pool, err := net.ParseCIDR(ovpn.NetworkIPv6)
+	if err != nil || pool.IP.To4() != nil {
+		return nil, &FieldError{Field: "NetworkIPv6", Message: "NetworkIPv6 must be IPv6 subnet like fd00::/64"}
+	}
+	return pool, nil
+}
+
+// Arguments of server directive: network address and netmask
+func (ovpn OpenVPNServer) ServerArgs() string {
+	pool, err := ovpn.Pool()
+	if err != nil {
+		return ovpn.Network
+	}
+	return pool.IP.String() + " " + net.IP(pool.Mask).String()
+}
+
+// Location of status file: StatusFile or default openvpn-status.log. Relative path is resolved by OpenVPN
+// against its working directory
+func (ovpn OpenVPNServer) StatusLog() string {
+	if ovpn.StatusFile != "" {
+		return ovpn.StatusFile
+	}
+	return "openvpn-status.log"
+}
+
+// Status file for reading: relative StatusLog is resolved against ConfigDir (if set) instead of working directory
+// of current process. Other relative paths (PersistIPFile, CRLFile, Keys) are not resolved
+func (ovpn OpenVPNServer) StatusPath() string {
+	file := ovpn.StatusLog()
+	if filepath.IsAbs(file) || ovpn.ConfigDir == "" {
+		return file
+	}
+	return filepath.Join(ovpn.ConfigDir, file)
+}
+
+// Management interface listens unix socket (ManagementAddr has no port)
+func (ovpn OpenVPNServer) ManagementUnix() bool {
+	_, _, err := net.SplitHostPort(ovpn.ManagementAddr)
+	return err != nil
+}
+
+// Arguments of management directive
+func (ovpn OpenVPNServer) ManagementArgs() string {
+	args := ovpn.ManagementAddr + " unix"
+	if !ovpn.ManagementUnix() {
+		host, port, _ := net.SplitHostPort(ovpn.ManagementAddr)
+		args = host + " " + port
+	}
+	if ovpn.ManagementPasswordFile != "" {
+		args += " " + ovpn.ManagementPasswordFile
+	}
+	return args
+}
+
 // Base file name of CA certificate
 func (ovpn OpenVPNServer) BaseCACertFile() string {
 	return path.Base(ovpn.Keys.CA.Certificate)
 }
 
-// Append static ip for client to PersistIPFile. It doesn't check unique (use ListStaticIP before)
+// Add static IPv4 for client to PersistIPFile. Address is checked by ValidateStaticIP. Returns error wrapping
+// ErrStaticIPExists if client already has static IP (use SetStaticIP to change it) or ErrIPConflict if address is used
 func (ovpn OpenVPNServer) AddStaticIP(client string, ip string) error {
-	f, err := os.OpenFile(ovpn.PersistIPFile, os.O_APPEND | os.O_WRONLY, 0600)
-	if err != nil {
-		return err
-	}
-	defer f.Close()
-	if _, err = f.WriteString(client + "," + ip + "\n"); err != nil {
-		return err
-	}
-	return nil
+	return ovpn.writeStaticIP(StaticIP{Client: client, IPv4: ip}, false)
 }
 
-// Read and parse PersistIPFile - list of client and static ip pairs
+// Read and parse PersistIPFile - map of client to static IPv4 (see StaticIPs for IPv6 and records order)
 func (ovpn OpenVPNServer) ListStaticIP() (map[string]string, error) {
-	data, err := ioutil.ReadFile(ovpn.PersistIPFile)
+	entries, err := ovpn.StaticIPs()
 	if err != nil {
 		return nil, err
 	}
 	ips := make(map[string]string)
-	lines := strings.Split(string(data), "\n")
-	for _, line := range lines {
-		kv := strings.Split(line, ",")
-		if len(kv) == 2 {
-			ips[strings.TrimSpace(kv[0])] = strings.TrimSpace(kv[1])
-		}
+	for _, entry := range entries {
+		ips[entry.Client] = entry.IPv4
 	}
 	return ips, nil
 }
 
-// Read, parse, exclude client and save PersistIPFile
+// Read, parse, exclude client and save PersistIPFile. Other records, comments and order are preserved
 func (ovpn OpenVPNServer) RemoveStaticIP(client string) error {
-	items, err := ovpn.ListStaticIP()
+	unlock, err := ovpn.lockStaticIP()
 	if err != nil {
 		return err
 	}
-	delete(items, client)
-	f, err := os.Create(ovpn.PersistIPFile)
+	defer unlock()
+	ipp, err := ReadIPP(ovpn.PersistIPFile)
 	if err != nil {
 		return err
 	}
-	defer f.Close()
-	for klient, ip := range items {
-		if _, err = f.WriteString(klient + "," + ip + "\n"); err != nil {
-			return err
-		}
+	if !ipp.Remove(client) {
+		return nil
 	}
-	return nil
+	return ipp.Save(ovpn.PersistIPFile)
 }
 
-// Check required parameters like port, protocol and others
+// Check required parameters like port, protocol and others. Returns *FieldError with name of invalid field
 func (ovpn OpenVPNServer) CheckRequiredFields() error {
 	if ovpn.Port == 0 {
-		return errors.New("Port must be non-zero positive value")
+		return &FieldError{Field: "Port", Message: "Port must be non-zero positive value"}
 	}
 	if ovpn.Protocol != "udp" && ovpn.Protocol != "tcp" {
-		return errors.New("Unknown protocol " + ovpn.Protocol + ": must be udp or tcp")
+		return &FieldError{Field: "Protocol", Message: "Unknown protocol " + ovpn.Protocol + ": must be udp or tcp"}
+	}
+	// configuration without server directive (like server-bridge) has no pool but is still usable for clients
+	if _, err := ovpn.Pool(); err != nil && !ovpn.networkUnknown {
+		return err
+	}
+	if _, err := ovpn.PoolIPv6(); err != nil {
+		return err
 	}
-	if ovpn.Keys.CA.Certificate == "" || ovpn.Keys.Server.Key == "" || ovpn.Keys.DiffieHellman == "" || ovpn.Keys.Server.Certificate == "" {
-		return errors.New("CA cert, Server key/cert and Diffie-Hellman pem must be")
+	required := []struct{ field, name, value string }{
+		{"Keys.CA.Certificate", "CA certificate", ovpn.Keys.CA.Certificate},
+		{"Keys.Server.Key", "Server key", ovpn.Keys.Server.Key},
+		{"Keys.DiffieHellman", "Diffie-Hellman parameters", ovpn.Keys.DiffieHellman},
+		{"Keys.Server.Certificate", "Server certificate", ovpn.Keys.Server.Certificate},
+	}
+	for _, r := range required {
+		if r.value == "" {
+			return &FieldError{Field: r.field, Message: r.name + " file (" + r.field + ") must be set"}
+		}
 	}
 	return nil
 }
 
-// Create initial server configuration file into targetDir
+// Create initial server configuration file into targetDir and empty PersistIPFile if it does not exist
 func (ovpn OpenVPNServer) InitialConfig(targetDir string) error {
+	return ovpn.InitialConfigFile(targetDir, "server.conf")
+}
+
+// Same as InitialConfig but configuration is written to fileName in targetDir
+func (ovpn OpenVPNServer) InitialConfigFile(targetDir, fileName string) error {
 	if err := ovpn.CheckRequiredFields(); err != nil {
 		return err
 	}
@@ -167,23 +363,21 @@ func (ovpn OpenVPNServer) InitialConfig(targetDir string) error {
 			return err
 		}
 		ovpn.PersistIPFile = ipp
-		f, err := os.Create(ovpn.PersistIPFile)
-		if err != nil {
-			return err
+		// existing static IPs are kept
+		if !fileExists(ovpn.PersistIPFile) {
+			if err = writeFileAtomic(ovpn.PersistIPFile, nil, 0644); err != nil {
+				return err
+			}
 		}
-		f.Close()
-	}
-	target = path.Join(target, "server.conf")
-	templ, err := template.New("").Parse(vpnConf)
-	if err != nil {
-		return err
 	}
-	f, err := os.Create(target)
+	target = path.Join(target, fileName)
+	templ, err := ovpn.serverTemplate()
 	if err != nil {
 		return err
 	}
-	defer f.Close()
-	return templ.Execute(f, ovpn)
+	return writeAtomic(target, 0644, func(w io.Writer) error {
+		return templ.Execute(w, ovpn)
+	})
 }
 
 // Create TLS key into keysDir as ta.key file and sets TlsKey property.
@@ -197,97 +391,239 @@ func (ovpn *OpenVPNServer) BuildTLSKey(keysDir string) error {
 	if err != nil {
 		return err
 	}
-	cmd := exec.Command("openvpn", "--genkey", "--secret", path.Join(v, "ta.key"))
+	// key is generated near target and renamed: existing ta.key is never left partially written
+	tmp := path.Join(v, ".ta.key.tmp")
+	defer os.Remove(tmp)
+	cmd := exec.Command("openvpn", "--genkey", "--secret", tmp)
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
-	if err = cmd.Run(); err == nil {
-		ovpn.TlsKey = path.Join(v, "ta.key")
+	if err = cmd.Run(); err != nil {
+		return &ToolError{Tool: "openvpn", Err: err}
 	}
-	return err
+	if err = os.Rename(tmp, path.Join(v, "ta.key")); err != nil {
+		return err
+	}
+	ovpn.TlsKey = path.Join(v, "ta.key")
+	return nil
 }
 
-// Create client configuration based on easy-rsa keys. It copies (really it links) all required files into targetDir
-// and creates client.conf
-func (ovpn OpenVPNServer) BuildClientConf(targetDir string, clientCert, clientKey string) error {
-	if len(ovpn.Addresses) == 0 {
-		return errors.New("No public addresses")
+// Files shipped to client: CA certificate, client certificate and key and TLS key (if set).
+// Server-only files (server certificate/key, Diffie-Hellman parameters) are never included
+func (ovpn OpenVPNServer) ClientFiles(clientCert, clientKey string) []string {
+	files := []string{ovpn.Keys.CA.Certificate, clientCert, clientKey}
+	if ovpn.TlsKey != "" {
+		files = append(files, ovpn.TlsKey)
 	}
-	if err := ovpn.CheckRequiredFields(); err != nil {
+	return files
+}
+
+// Create client configuration based on easy-rsa keys. It links files from ClientFiles into targetDir (or copies them
+// if targetDir is on another file system) and creates client.conf (or <client>.ovpn for ClientPlatform).
+// Existing client directory is overwritten: previously generated files which are not used anymore (ta.key after
+// TlsKey is unset, configuration of other platform) are removed, other files are kept. Directory with any of
+// ClientFiles (like keys directory) is refused
+func (ovpn OpenVPNServer) BuildClientConf(targetDir string, clientCert, clientKey string) error {
+	if err := ovpn.checkClientFields(); err != nil {
 		return err
 	}
 	if err := os.MkdirAll(targetDir, 0755); err != nil {
 		return err
 	}
-	err := os.Link(ovpn.Keys.DiffieHellman, path.Join(targetDir, path.Base(ovpn.Keys.DiffieHellman)))
+	dir, err := os.Stat(targetDir)
 	if err != nil {
 		return err
 	}
-	err = os.Link(ovpn.Keys.Server.Certificate, path.Join(targetDir, path.Base(ovpn.Keys.Server.Certificate)))
+	client := clientNameFromCert(clientCert)
+	target := path.Join(targetDir, ovpn.ClientPlatform.ConfigFile(client))
+	keep := map[string]bool{path.Base(target): true}
+	for _, file := range ovpn.ClientFiles(clientCert, clientKey) {
+		if src, err := os.Stat(filepath.Dir(file)); err == nil && os.SameFile(src, dir) {
+			return errors.New("Client directory " + targetDir + " contains source file " + file)
+		}
+		keep[path.Base(file)] = true
+	}
+	for _, file := range []string{"client.conf", client + ".ovpn", "ta.key"} {
+		if keep[file] {
+			continue
+		}
+		if err = os.Remove(path.Join(targetDir, file)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	for _, file := range ovpn.ClientFiles(clientCert, clientKey) {
+		mode := os.FileMode(0644)
+		if file == clientKey || file == ovpn.TlsKey {
+			mode = 0600
+		}
+		if err := linkOrCopy(file, path.Join(targetDir, path.Base(file)), mode); err != nil {
+			return err
+		}
+	}
+	templ, err := ovpn.clientTemplate()
 	if err != nil {
 		return err
 	}
-	err = os.Link(ovpn.Keys.CA.Certificate, path.Join(targetDir, path.Base(ovpn.Keys.CA.Certificate)))
-	if err != nil {
+	params := ClientTemplateData{OpenVPNServer: ovpn, ClientName: client}
+	params.ClientCertFile = path.Base(clientCert)
+	params.ClientKeyFile = path.Base(clientKey)
+	return writeAtomic(target, 0644, func(w io.Writer) error {
+		return templ.Execute(w, params)
+	})
+}
+
+// Create single-file client configuration (.ovpn) with CA, client cert/key and TLS key embedded as inline blocks.
+// Suitable for mobile clients like OpenVPN Connect or Tunnelblick
+func (ovpn OpenVPNServer) WriteInlineClientConf(w io.Writer, clientCert, clientKey string) error {
+	if err := ovpn.checkClientFields(); err != nil {
+		return err
+	}
+	params := ClientTemplateData{OpenVPNServer: ovpn, ClientName: clientNameFromCert(clientCert), Inline: true}
+	var err error
+	if params.InlineCA, err = readPEM(ovpn.Keys.CA.Certificate); err != nil {
+		return err
+	}
+	if params.InlineCert, err = readPEM(clientCert); err != nil {
+		return err
+	}
+	if params.InlineKey, err = readPEM(clientKey); err != nil {
 		return err
 	}
 	if ovpn.TlsKey != "" {
-		err = os.Link(ovpn.TlsKey, path.Join(targetDir, path.Base(ovpn.TlsKey)))
+		data, err := ioutil.ReadFile(ovpn.TlsKey)
 		if err != nil {
 			return err
 		}
+		params.InlineTLSKey = strings.TrimSpace(string(data)) + "\n"
 	}
-	err = os.Link(clientCert, path.Join(targetDir, path.Base(clientCert)))
+	templ, err := ovpn.clientTemplate()
 	if err != nil {
 		return err
 	}
-	err = os.Link(clientKey, path.Join(targetDir, path.Base(clientKey)))
-	if err != nil {
-		return err
+	return templ.Execute(w, params)
+}
+
+// Same as WriteInlineClientConf but returns content of configuration
+func (ovpn OpenVPNServer) InlineClientConf(clientCert, clientKey string) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	if err := ovpn.WriteInlineClientConf(buf, clientCert, clientKey); err != nil {
+		return nil, err
 	}
-	target := path.Join(targetDir, "client.conf")
-	templ, err := template.New("").Parse(clientConf)
-	if err != nil {
-		return err
+	return buf.Bytes(), nil
+}
+
+// Custom server template or default one
+func (ovpn OpenVPNServer) serverTemplate() (*template.Template, error) {
+	if ovpn.ServerTemplate != nil {
+		return ovpn.ServerTemplate, nil
 	}
-	f, err := os.Create(target)
+	return template.New("").Parse(vpnConf)
+}
+
+// Custom client template or default one
+func (ovpn OpenVPNServer) clientTemplate() (*template.Template, error) {
+	if ovpn.ClientTemplate != nil {
+		return ovpn.ClientTemplate, nil
+	}
+	return template.New("").Parse(clientConf)
+}
+
+// Check fields required for client configuration
+func (ovpn OpenVPNServer) checkClientFields() error {
+	if len(ovpn.Addresses) == 0 && len(ovpn.Remotes) == 0 {
+		return &FieldError{Field: "Addresses", Message: "No public addresses"}
+	}
+	for _, remote := range ovpn.Remotes {
+		if remote.Host == "" {
+			return &FieldError{Field: "Remotes", Message: "Remote host is required"}
+		}
+		if remote.Protocol != "" && !isRemoteProto(remote.Protocol) {
+			return &FieldError{Field: "Remotes", Message: "Unknown protocol " + remote.Protocol + " of remote " + remote.Host}
+		}
+	}
+	return ovpn.CheckRequiredFields()
+}
+
+// Protocol accepted by remote directive of client
+func isRemoteProto(proto string) bool {
+	switch proto {
+	case "udp", "udp4", "udp6", "tcp", "tcp4", "tcp6", "tcp-client":
+		return true
+	}
+	return false
+}
+
+// Read only PEM blocks from file (easy-rsa puts human-readable dump before certificate)
+func readPEM(file string) (string, error) {
+	data, err := ioutil.ReadFile(file)
 	if err != nil {
-		return err
+		return "", err
+	}
+	buf := &bytes.Buffer{}
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if err = pem.Encode(buf, block); err != nil {
+			return "", err
+		}
 	}
-	defer f.Close()
-	params := struct {OpenVPNServer
-					  ClientCertFile string
-					  ClientKeyFile  string    }{}
-	params.OpenVPNServer = ovpn
-	params.ClientCertFile = path.Base(clientCert)
-	params.ClientKeyFile = path.Base(clientKey)
-	return templ.Execute(f, params)
+	if buf.Len() == 0 {
+		return "", errors.New("No PEM data in " + file)
+	}
+	return buf.String(), nil
 }
 
-// Read necessary parameters from OpenSSL server configuration file
+// Read necessary parameters from OpenVPN server configuration file. Keys embedded as inline blocks
+// are marked by InlineFile. Use ReadConfig to access and edit all directives
 func OpenServerConf(serverConf string) (OpenVPNServer, error) {
-	server := OpenVPNServer{}
-	content, err := ioutil.ReadFile(serverConf)
+	cfg, err := ReadConfig(serverConf)
+	if err != nil {
+		return OpenVPNServer{}, err
+	}
+	server, err := ServerFromConfig(cfg)
 	if err != nil {
 		return server, err
 	}
-	lines := strings.Split(string(content), "\n")
+	server.ConfigDir, err = filepath.Abs(filepath.Dir(serverConf))
+	return server, err
+}
 
-	for _, line := range lines {
-		line = strings.TrimSpace(line)
-		if line == "" || line[0] == '#' || line[0] == ';' {
+// Read necessary parameters from parsed server or client configuration
+func ServerFromConfig(cfg *Config) (OpenVPNServer, error) {
+	server := OpenVPNServer{networkUnknown: true}
+	for _, node := range cfg.Nodes {
+		if node.Kind == NodeInline {
+			switch node.Name {
+			case "ca": server.Keys.CA.Certificate = InlineFile
+			case "cert": server.Keys.Server.Certificate = InlineFile
+			case "key": server.Keys.Server.Key = InlineFile
+			case "dh": server.Keys.DiffieHellman = InlineFile
+			case "tls-auth": server.TlsKey = InlineFile
+			case "tls-crypt":
+				server.TlsKey = InlineFile
+				server.TlsCrypt = true
+			case "crl-verify": server.CRLFile = InlineFile
+			}
+			continue
+		}
+		if node.Kind != NodeDirective {
 			continue
 		}
-		if line == "client-to-client" {
+		if node.Name == "client-to-client" {
 			server.ClientToClient = true
 			continue
 		}
-
-		kv := strings.SplitN(line, " ", 2)
-		if len(kv) < 2 {
+		if node.Name == "remote-random" {
+			server.RemoteRandom = true
+			continue
+		}
+		if len(node.Args) == 0 {
 			continue
 		}
-		val := strings.TrimSpace(kv[1])
-		switch strings.TrimSpace(kv[0]) {
+		val := node.Args[0]
+		switch node.Name {
 		case "port":
 			prt, err := strconv.ParseUint(val, 10, 16)
 			if err != nil {
@@ -301,8 +637,89 @@ func OpenServerConf(serverConf string) (OpenVPNServer, error) {
 		case "key": server.Keys.Server.Key = val
 		case "dh": server.Keys.DiffieHellman = val
 		case "ifconfig-pool-persist": server.PersistIPFile = val
-		case "tls-auth": server.TlsKey = strings.Split(val, " ")[0] //Chop direction
+		case "tls-auth": server.TlsKey = val
+		case "tls-crypt":
+			server.TlsKey = val
+			server.TlsCrypt = true
+		case "status": server.StatusFile = val
+		case "crl-verify": server.CRLFile = val
+		case "client-config-dir": server.ClientConfigDir = val
+		case "server-ipv6": server.NetworkIPv6 = val
+		case "server":
+			if len(node.Args) > 1 {
+				server.networkUnknown = false
+				// not IPv4 or not canonical mask has no size: keep as is to be rejected by Pool
+				ones, bits := net.IPMask(net.ParseIP(node.Args[1]).To4()).Size()
+				if bits == 0 {
+					server.Network = val + "/" + node.Args[1]
+				} else {
+					server.Network = val + "/" + strconv.Itoa(ones)
+				}
+			}
+		case "management":
+			if len(node.Args) > 1 && node.Args[1] == "unix" {
+				server.ManagementAddr = val
+			} else if len(node.Args) > 1 {
+				server.ManagementAddr = net.JoinHostPort(val, node.Args[1])
+			}
+			if len(node.Args) > 2 {
+				server.ManagementPasswordFile = node.Args[2]
+			}
+		case "status-version":
+			ver, err := strconv.Atoi(val)
+			if err != nil {
+				return server, err
+			}
+			server.StatusVersion = ver
+		case "remote":
+			remote := Remote{Host: val}
+			if len(node.Args) > 1 {
+				prt, err := strconv.ParseUint(node.Args[1], 10, 16)
+				if err != nil {
+					return server, err
+				}
+				remote.Port = uint16(prt)
+			}
+			if len(node.Args) > 2 {
+				remote.Protocol = node.Args[2]
+			}
+			server.Remotes = append(server.Remotes, remote)
+		case "connect-retry":
+			retry, err := strconv.Atoi(val)
+			if err != nil {
+				return server, err
+			}
+			server.ConnectRetry = retry
+			if len(node.Args) > 1 {
+				if server.ConnectRetryMax, err = strconv.Atoi(node.Args[1]); err != nil {
+					return server, err
+				}
+			}
+		case "server-poll-timeout":
+			timeout, err := strconv.Atoi(val)
+			if err != nil {
+				return server, err
+			}
+			server.ServerPollTimeout = timeout
 		}
 	}
+	// client configuration has no port directive: leading remotes on default port become Addresses
+	if server.Port == 0 && len(server.Remotes) > 0 {
+		server.Port = server.Remotes[0].Port
+	}
+	for len(server.Remotes) > 0 && server.Remotes[0].Protocol == "" &&
+		(server.Remotes[0].Port == 0 || server.Remotes[0].Port == server.Port) {
+		server.Addresses = append(server.Addresses, server.Remotes[0].Host)
+		server.Remotes = server.Remotes[1:]
+	}
+	if len(server.Remotes) == 0 {
+		server.Remotes = nil
+	}
 	return server, nil
-}
\ No newline at end of file
+}
+
+// Read remotes, protocol and other parameters from OpenVPN client configuration file (see ServerFromConfig).
+// Client certificate and key are stored in Keys.Server
+func OpenClientConf(clientConf string) (OpenVPNServer, error) {
+	return OpenServerConf(clientConf)
+}
@@ -4,6 +4,12 @@ import (
 	"os"
 	"io/ioutil"
 	"bytes"
+	"path"
+	"encoding/pem"
+	"strings"
+	"text/template"
+	"reflect"
+	"errors"
 )
 
 func getTestOVPNServer() OpenVPNServer {
@@ -186,7 +192,7 @@ func TestOVPNAddAndCheckStaticIP(t *testing.T) {
 	if _, err := os.Stat("test/server.conf"); os.IsNotExist(err) {
 		t.Error("Server configuration not created")
 	}
-	err = ovpn.AddStaticIP("client", "10.1.2.3")
+	err = ovpn.AddStaticIP("client", "10.8.0.10")
 	if err != nil {
 		t.Fatal("Failed add static ip", err)
 	}
@@ -194,7 +200,7 @@ func TestOVPNAddAndCheckStaticIP(t *testing.T) {
 	if err != nil {
 		t.Fatal("Failed list static ips", err)
 	}
-	if ip := list["client"]; ip != "10.1.2.3" {
+	if ip := list["client"]; ip != "10.8.0.10" {
 		t.Fatal("Static ip not added")
 	}
 }
@@ -215,4 +221,282 @@ func TestOVPNClientConfig(t *testing.T) {
 	if err != nil {
 		t.Fatal("Build client config", err)
 	}
-}
\ No newline at end of file
+}
+
+// Create fake key files (without easy-rsa) in dir and server which refers to them
+func getFakeOVPNServer(t *testing.T, dir string) OpenVPNServer {
+	write := func(name, content string) string {
+		file := path.Join(dir, name)
+		if err := ioutil.WriteFile(file, []byte(content), 0600); err != nil {
+			t.Fatal("Write fake key file", err)
+		}
+		return file
+	}
+	fakePEM := func(kind, body string) string {
+		return string(pem.EncodeToMemory(&pem.Block{Type: kind, Bytes: []byte(body)}))
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal("Create fake keys dir", err)
+	}
+	ovpn := OpenVPNServer{
+		Protocol:"udp",
+		Addresses:[]string{"127.0.0.1"},
+		Port:1194,
+	}
+	ovpn.Keys.CA.Certificate = write("ca.crt", "Certificate:\n    Data: dump\n" + fakePEM("CERTIFICATE", "ca"))
+	ovpn.Keys.CA.Key = write("ca.key", fakePEM("PRIVATE KEY", "ca-key"))
+	ovpn.Keys.Server.Certificate = write("server.crt", fakePEM("CERTIFICATE", "server"))
+	ovpn.Keys.Server.Key = write("server.key", fakePEM("PRIVATE KEY", "server-key"))
+	ovpn.Keys.DiffieHellman = write("dh2048.pem", fakePEM("DH PARAMETERS", "dh"))
+	ovpn.TlsKey = write("ta.key", "-----BEGIN OpenVPN Static key V1-----\n0123456789abcdef\n-----END OpenVPN Static key V1-----\n")
+	write("ivan.crt", fakePEM("CERTIFICATE", "ivan"))
+	write("ivan.key", fakePEM("PRIVATE KEY", "ivan-key"))
+	return ovpn
+}
+
+func TestOVPNInlineClientConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "inline")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	server := getFakeOVPNServer(t, dir)
+	data, err := server.InlineClientConf(path.Join(dir, "ivan.crt"), path.Join(dir, "ivan.key"))
+	if err != nil {
+		t.Fatal("Build inline client config", err)
+	}
+	conf := string(data)
+	for _, block := range []string{"<ca>", "</ca>", "<cert>", "</cert>", "<key>", "</key>", "<tls-auth>", "</tls-auth>", "key-direction 1"} {
+		if !strings.Contains(conf, block) {
+			t.Error("Inline config has no", block)
+		}
+	}
+	if strings.Contains(conf, "Data: dump") {
+		t.Error("Certificate dump must be stripped")
+	}
+	if strings.Contains(conf, "ca ca.crt") || strings.Contains(conf, "tls-auth ta.key") {
+		t.Error("Inline config must not refer to files")
+	}
+
+	server.TlsCrypt = true
+	data, err = server.InlineClientConf(path.Join(dir, "ivan.crt"), path.Join(dir, "ivan.key"))
+	if err != nil {
+		t.Fatal("Build inline client config with tls-crypt", err)
+	}
+	if !strings.Contains(string(data), "<tls-crypt>") || strings.Contains(string(data), "<tls-auth>") {
+		t.Error("Inline config must use tls-crypt block")
+	}
+}
+
+func TestOVPNClientConfigOverwrite(t *testing.T) {
+	dir, err := ioutil.TempDir("", "overwrite")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	server := getFakeOVPNServer(t, dir)
+	for i := 0; i < 2; i++ {
+		err = server.BuildClientConf(path.Join(dir, "ivan-conf"), path.Join(dir, "ivan.crt"), path.Join(dir, "ivan.key"))
+		if err != nil {
+			t.Fatal("Build client config", i, err)
+		}
+	}
+	target := path.Join(dir, "ivan-conf")
+	if err = ioutil.WriteFile(path.Join(target, "ivan.ovpn"), []byte("stale"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.Mkdir(path.Join(target, "extra"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err = ioutil.WriteFile(path.Join(target, "notes.txt"), []byte("user file"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	server.TlsKey = ""
+	if err = server.BuildClientConf(target, path.Join(dir, "ivan.crt"), path.Join(dir, "ivan.key")); err != nil {
+		t.Fatal("Build client config without TLS key", err)
+	}
+	for _, name := range []string{"ta.key", "ivan.ovpn"} {
+		if fileExists(path.Join(target, name)) {
+			t.Error("Stale file not removed", name)
+		}
+	}
+	for _, name := range []string{"client.conf", "ca.crt", "ivan.crt", "ivan.key", "extra", "notes.txt"} {
+		if !fileExists(path.Join(target, name)) {
+			t.Error("File of client directory removed", name)
+		}
+	}
+}
+
+func TestOVPNClientConfigKeysDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "keysdir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	server := getFakeOVPNServer(t, dir)
+	before, _ := ioutil.ReadDir(dir)
+	if err = server.BuildClientConf(dir, path.Join(dir, "ivan.crt"), path.Join(dir, "ivan.key")); err == nil {
+		t.Error("Directory with source files must be refused")
+	}
+	after, _ := ioutil.ReadDir(dir)
+	if len(after) != len(before) {
+		t.Error("Files of keys directory changed", len(before), len(after))
+	}
+	if data, err := ioutil.ReadFile(server.Keys.CA.Certificate); err != nil || len(data) == 0 {
+		t.Error("CA certificate removed", err)
+	}
+}
+
+func TestOVPNExtraDirectives(t *testing.T) {
+	dir, err := ioutil.TempDir("", "extra")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	server := getFakeOVPNServer(t, dir)
+	server.ExtraServerDirectives = []string{"push \"redirect-gateway def1\"", "duplicate-cn"}
+	server.ExtraClientDirectives = []string{"auth-nocache"}
+	if err = server.InitialConfig(dir); err != nil {
+		t.Fatal("Create server config", err)
+	}
+	data, err := ioutil.ReadFile(path.Join(dir, "server.conf"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), "push \"redirect-gateway def1\"\nduplicate-cn\n") {
+		t.Error("Extra server directives not added")
+	}
+	conf, err := server.InlineClientConf(path.Join(dir, "ivan.crt"), path.Join(dir, "ivan.key"))
+	if err != nil {
+		t.Fatal("Create client config", err)
+	}
+	if !strings.Contains(string(conf), "\nauth-nocache\n") {
+		t.Error("Extra client directives not added")
+	}
+}
+
+func TestOVPNCustomTemplates(t *testing.T) {
+	dir, err := ioutil.TempDir("", "custom")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	server := getFakeOVPNServer(t, dir)
+	server.ServerTemplate = template.Must(template.New("").Parse("port {{.Port}}\n"))
+	server.ClientTemplate = template.Must(template.New("").Parse("# {{.ClientName}}\nremote {{index .Addresses 0}} {{.Port}}\n"))
+	if err = server.InitialConfig(dir); err != nil {
+		t.Fatal("Create server config", err)
+	}
+	data, err := ioutil.ReadFile(path.Join(dir, "server.conf"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "port 1194\n" {
+		t.Error("Custom server template not used", string(data))
+	}
+	conf, err := server.InlineClientConf(path.Join(dir, "ivan.crt"), path.Join(dir, "ivan.key"))
+	if err != nil {
+		t.Fatal("Create client config", err)
+	}
+	if string(conf) != "# ivan\nremote 127.0.0.1 1194\n" {
+		t.Error("Custom client template not used", string(conf))
+	}
+}
+
+func TestOVPNNetworkRoundTrip(t *testing.T) {
+	cfg, err := ParseConfig(strings.NewReader("server 192.168.100.0 255.255.255.128\nserver-ipv6 fd00::/64\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ovpn, err := ServerFromConfig(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ovpn.Network != "192.168.100.0/25" || ovpn.NetworkIPv6 != "fd00::/64" {
+		t.Error("Bad parsed networks", ovpn.Network, ovpn.NetworkIPv6)
+	}
+	if ovpn.ServerArgs() != "192.168.100.0 255.255.255.128" {
+		t.Error("Bad server arguments", ovpn.ServerArgs())
+	}
+	if (OpenVPNServer{}).ServerArgs() != "10.8.0.0 255.255.255.0" {
+		t.Error("Bad default server arguments")
+	}
+	for _, conf := range []string{"port 1194\n", "server 10.8.0.0 255.0.255.0\n", "server 10.8.0.0 bad\n"} {
+		cfg, err := ParseConfig(strings.NewReader(conf))
+		if err != nil {
+			t.Fatal(err)
+		}
+		ovpn, err := ServerFromConfig(cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var fe *FieldError
+		if _, err = ovpn.Pool(); !errors.As(err, &fe) || fe.Field != "Network" {
+			t.Error("Unknown network must be reported", conf, err)
+		}
+		if err = ovpn.ValidateStaticIP(StaticIP{Client: "ivan", IPv4: "10.8.0.2"}); !errors.As(err, &fe) {
+			t.Error("Static IP checked against guessed network", conf, err)
+		}
+	}
+}
+
+func TestOVPNClientRemotes(t *testing.T) {
+	dir, err := ioutil.TempDir("", "remotes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	server := getFakeOVPNServer(t, dir)
+	data, err := server.InlineClientConf(path.Join(dir, "ivan.crt"), path.Join(dir, "ivan.key"))
+	if err != nil {
+		t.Fatal("Build default client config", err)
+	}
+	if !strings.Contains(string(data), "\nremote 127.0.0.1 1194\n\nresolv-retry") {
+		t.Error("Default remotes changed", string(data))
+	}
+
+	server.Remotes = []Remote{{Host: "vpn.example.com", Port: 443, Protocol: "tcp"}, {Host: "backup.example.com"}}
+	server.RemoteRandom = true
+	server.ConnectRetry = 2
+	server.ConnectRetryMax = 30
+	server.ServerPollTimeout = 10
+	data, err = server.InlineClientConf(path.Join(dir, "ivan.crt"), path.Join(dir, "ivan.key"))
+	if err != nil {
+		t.Fatal("Build client config with remotes", err)
+	}
+	conf := string(data)
+	expected := "remote 127.0.0.1 1194\nremote vpn.example.com 443 tcp\nremote backup.example.com 1194\n" +
+		"remote-random\nconnect-retry 2 30\nserver-poll-timeout 10\n"
+	if !strings.Contains(conf, expected) {
+		t.Error("Bad remotes", conf)
+	}
+	file := path.Join(dir, "ivan.ovpn")
+	if err = ioutil.WriteFile(file, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	client, err := OpenClientConf(file)
+	if err != nil {
+		t.Fatal("Open client config", err)
+	}
+	if client.Port != 1194 || client.Protocol != "udp" || !reflect.DeepEqual(client.Addresses, []string{"127.0.0.1"}) {
+		t.Error("Bad parsed primary remote", client.Port, client.Protocol, client.Addresses)
+	}
+	remotes := []Remote{{Host: "vpn.example.com", Port: 443, Protocol: "tcp"}, {Host: "backup.example.com", Port: 1194}}
+	if !reflect.DeepEqual(client.Remotes, remotes) {
+		t.Error("Bad parsed remotes", client.Remotes)
+	}
+	if !client.RemoteRandom || client.ConnectRetry != 2 || client.ConnectRetryMax != 30 || client.ServerPollTimeout != 10 {
+		t.Error("Bad parsed options", client.RemoteRandom, client.ConnectRetry, client.ConnectRetryMax, client.ServerPollTimeout)
+	}
+
+	for _, proto := range []string{"udp4", "udp6", "tcp4", "tcp6", "tcp-client"} {
+		server.Remotes = []Remote{{Host: "vpn.example.com", Protocol: proto}}
+		if _, err = server.InlineClientConf(path.Join(dir, "ivan.crt"), path.Join(dir, "ivan.key")); err != nil {
+			t.Error("Protocol of remote rejected", proto, err)
+		}
+	}
+	server.Remotes = []Remote{{Host: "vpn.example.com", Protocol: "sctp"}}
+	if _, err = server.InlineClientConf(path.Join(dir, "ivan.crt"), path.Join(dir, "ivan.key")); !errors.Is(err, ErrInvalidField) {
+		t.Error("Unknown protocol of remote must be rejected", err)
+	}
+}
@@ -0,0 +1,68 @@
+package vpnc
+import (
+	"strings"
+	"path"
+	"errors"
+)
+
+// Target platform of client configuration. Empty value means legacy Linux-centric client.conf
+type Platform string
+
+const (
+	PlatformLinux        Platform = "linux"         // Linux with DNS updates by update-resolv-conf (Debian resolvconf)
+	PlatformLinuxSystemd Platform = "linux-systemd" // Linux with DNS updates by update-systemd-resolved
+	PlatformWindows      Platform = "windows"       // OpenVPN GUI/Connect for Windows
+	PlatformMacOS        Platform = "macos"         // Tunnelblick or OpenVPN Connect for macOS
+	PlatformAndroid      Platform = "android"       // OpenVPN Connect or OpenVPN for Android
+	PlatformIOS          Platform = "ios"           // OpenVPN Connect for iOS
+)
+
+// All known platforms
+var Platforms = []Platform{PlatformLinux, PlatformLinuxSystemd, PlatformWindows, PlatformMacOS, PlatformAndroid, PlatformIOS}
+
+// Parse platform name (case insensitive). Empty name means legacy configuration
+func ParsePlatform(name string) (Platform, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if name == "" {
+		return "", nil
+	}
+	for _, p := range Platforms {
+		if string(p) == name {
+			return p, nil
+		}
+	}
+	return "", errors.New("Unknown platform " + name)
+}
+
+// Name of client configuration file: client.conf for legacy configuration and <client>.ovpn for others
+func (p Platform) ConfigFile(client string) string {
+	if p == "" {
+		return "client.conf"
+	}
+	return client + ".ovpn"
+}
+
+// Script for updating DNS settings on client side. Empty if platform manages DNS by itself
+func (p Platform) DNSScript() string {
+	switch p {
+	case PlatformLinux: return "/etc/openvpn/update-resolv-conf"
+	case PlatformLinuxSystemd: return "/etc/openvpn/update-systemd-resolved"
+	}
+	return ""
+}
+
+// Script runs before tunnel is closed (required by update-systemd-resolved)
+func (p Platform) DownPre() bool {
+	return p == PlatformLinuxSystemd
+}
+
+// Block DNS servers on other network adapters to prevent DNS leaks (Windows only)
+func (p Platform) BlockOutsideDNS() bool {
+	return p == PlatformWindows
+}
+
+// Client name from certificate file: base name without extension
+func clientNameFromCert(clientCert string) string {
+	base := path.Base(clientCert)
+	return strings.TrimSuffix(base, path.Ext(base))
+}
@@ -0,0 +1,63 @@
+package vpnc
+import (
+	"testing"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+)
+
+func TestParsePlatform(t *testing.T) {
+	p, err := ParsePlatform(" Windows ")
+	if err != nil || p != PlatformWindows {
+		t.Error("Parse windows platform", p, err)
+	}
+	if p, err = ParsePlatform(""); err != nil || p != "" {
+		t.Error("Parse legacy platform", p, err)
+	}
+	if _, err = ParsePlatform("amiga"); err == nil {
+		t.Error("Unknown platform parsed")
+	}
+}
+
+func TestPlatformClientConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "platform")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	server := getFakeOVPNServer(t, dir)
+	cases := []struct {
+		platform Platform
+		file     string
+		present  []string
+		absent   []string
+	}{
+		{"", "client.conf", []string{"log /var/log/openvpn.log"}, []string{"block-outside-dns", "script-security"}},
+		{PlatformLinux, "ivan.ovpn", []string{"up /etc/openvpn/update-resolv-conf", "down /etc/openvpn/update-resolv-conf"}, []string{"log /var/log/openvpn.log", "down-pre"}},
+		{PlatformLinuxSystemd, "ivan.ovpn", []string{"up /etc/openvpn/update-systemd-resolved", "down-pre"}, []string{"log /var/log/openvpn.log"}},
+		{PlatformWindows, "ivan.ovpn", []string{"block-outside-dns"}, []string{"log /var/log/openvpn.log", "script-security"}},
+		{PlatformIOS, "ivan.ovpn", nil, []string{"log /var/log/openvpn.log", "script-security", "block-outside-dns"}},
+	}
+	for _, c := range cases {
+		server.ClientPlatform = c.platform
+		target := path.Join(dir, "conf-" + string(c.platform))
+		if err = server.BuildClientConf(target, path.Join(dir, "ivan.crt"), path.Join(dir, "ivan.key")); err != nil {
+			t.Fatal("Build client config for", c.platform, err)
+		}
+		data, err := ioutil.ReadFile(path.Join(target, c.file))
+		if err != nil {
+			t.Fatal("Read client config for", c.platform, err)
+		}
+		for _, line := range c.present {
+			if !strings.Contains(string(data), line) {
+				t.Error("Config for", c.platform, "has no", line)
+			}
+		}
+		for _, line := range c.absent {
+			if strings.Contains(string(data), line) {
+				t.Error("Config for", c.platform, "must not have", line)
+			}
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package vpnc
+import (
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// Issue client keys and pass them to function (build archive, register metadata and e.t.c.) as single transaction:
+// if issuing or function fails, certificate issued by this call is discarded - key files and index record are removed
+// and registry record is restored. So failed provisioning could be retried with the same name.
+// Nothing is rolled back if certificate was not issued (like ClientExistsError or ErrInvalidClientName).
+// Previous certificate revoked by ReissueRevoke stays revoked: restored registry record is marked revoked too
+func (er EasyRSA) ProvisionClient(name string, use func(keys ClientKeyFiles) error) (ClientKeyFiles, error) {
+	keys, record, err := er.issueClientKeys(name)
+	if err == nil && use != nil {
+		err = use(keys)
+	}
+	if err == nil || keys.Serial == nil {
+		return keys, err
+	}
+	if rerr := er.rollbackClientKeys(keys, record); rerr != nil {
+		return keys, fmt.Errorf("%w (rollback failed: %v)", err, rerr)
+	}
+	return keys, err
+}
+
+// Discard certificate issued by ProvisionClient and restore registry record (remove if record is nil).
+// Key files and registry are left as is if client was reissued by another call in the meantime
+func (er EasyRSA) rollbackClientKeys(keys ClientKeyFiles, record *ClientRecord) error {
+	unlock, err := er.Lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	current := certSerial(keys.Files.Certificate)
+	owned := current != nil && current.Cmp(keys.Serial) == 0
+	if err = er.removeIssued(keys, keys.Serial, owned); err != nil {
+		return err
+	}
+	if !owned {
+		return nil
+	}
+	if record == nil {
+		return er.Registry().Remove(keys.Name)
+	}
+	if record.Status == "valid" {
+		valid, err := er.hasValidCertificate(keys.Name)
+		if err != nil {
+			return err
+		}
+		if !valid {
+			now := time.Now()
+			record.Status = "revoked"
+			record.Revoked = &now
+		}
+	}
+	return er.Registry().Put(*record)
+}
+
+// Remove issued client certificate: record with serial in index, key files and copy of certificate (<serial>.pem)
+func (er EasyRSA) discardClientKeys(keys ClientKeyFiles, serial *big.Int) error {
+	unlock, err := er.Lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	return er.removeIssued(keys, serial, true)
+}
+
+// Remove record with serial from index and copy of certificate. Key files are removed only if withFiles.
+// Caller must hold Lock
+func (er EasyRSA) removeIssued(keys ClientKeyFiles, serial *big.Int, withFiles bool) error {
+	data, err := ioutil.ReadFile(er.IndexFile())
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	var kept []string
+	var removed []string
+	for _, line := range strings.SplitAfter(string(data), "\n") {
+		fields := strings.Split(line, "\t")
+		if len(fields) >= 6 {
+			if n, ok := new(big.Int).SetString(fields[3], 16); ok && n.Cmp(serial) == 0 {
+				removed = append(removed, path.Join(er.KeysDir(), fields[3] + ".pem"))
+				continue
+			}
+		}
+		kept = append(kept, line)
+	}
+	if len(removed) > 0 {
+		if err = writeFileAtomic(er.IndexFile(), []byte(strings.Join(kept, "")), 0644); err != nil {
+			return err
+		}
+	}
+	if withFiles {
+		removed = append(removed, keys.Files.Certificate, keys.Files.Key, keys.SigningRequest)
+	}
+	for _, file := range removed {
+		if err = os.Remove(file); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// Serial number of certificate in file or nil
+func certSerial(file string) *big.Int {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil
+	}
+	cert, err := parseCertificate(data)
+	if err != nil {
+		return nil
+	}
+	return cert.SerialNumber
+}
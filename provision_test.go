@@ -0,0 +1,201 @@
+package vpnc
+import (
+	"testing"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"runtime"
+	"strings"
+	"time"
+)
+
+func TestDiscardClientKeys(t *testing.T) {
+	dir, err := ioutil.TempDir("", "provision")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	rsa := getInstance()
+	rsa.KeyDir = dir
+	writeTestCert(t, dir, "ivan", time.Now().Add(time.Hour))
+	index := "V\t20500101000000Z\t\t02\tunknown\t/C=RU/CN=petr\n" +
+		"V\t20500101000000Z\t\t01\tunknown\t/C=RU/CN=ivan\n"
+	if err = ioutil.WriteFile(rsa.IndexFile(), []byte(index), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err = ioutil.WriteFile(path.Join(dir, "01.pem"), []byte("copy"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	keys := rsa.ClientKeys("ivan")
+	serial := certSerial(keys.Files.Certificate)
+	if serial == nil || serial.Int64() != 1 {
+		t.Fatal("Bad certificate serial", serial)
+	}
+	if err = rsa.discardClientKeys(keys, serial); err != nil {
+		t.Fatal("Discard keys", err)
+	}
+	data, _ := ioutil.ReadFile(rsa.IndexFile())
+	if string(data) != "V\t20500101000000Z\t\t02\tunknown\t/C=RU/CN=petr\n" {
+		t.Error("Index record not removed", string(data))
+	}
+	for _, file := range []string{keys.Files.Certificate, keys.Files.Key, path.Join(dir, "01.pem")} {
+		if _, err = os.Stat(file); !os.IsNotExist(err) {
+			t.Error("File not removed", file)
+		}
+	}
+}
+
+// Easy-rsa with fake pkitool and openssl (on PATH): pkitool issues certificate with serial 02 prepared in
+// separate directory, openssl revokes serial 01 in index
+func getFakeProvisionRSA(t *testing.T, dir string) EasyRSA {
+	if runtime.GOOS == "windows" {
+		t.Skip("Fake tools are shell scripts")
+	}
+	bin := path.Join(dir, "bin")
+	prepared := path.Join(dir, "prepared")
+	keys := path.Join(dir, "keys")
+	for _, d := range []string{bin, prepared, keys} {
+		if err := os.MkdirAll(d, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeTestCertSerial(t, prepared, "ivan", time.Now().Add(time.Hour), 2)
+	pkitool := "#!/bin/sh\n" +
+		"cp \"" + prepared + "/$1.crt\" \"$KEY_DIR/$1.crt\" && cp \"" + prepared + "/$1.key\" \"$KEY_DIR/$1.key\" &&\n" +
+		"cp \"" + prepared + "/$1.crt\" \"$KEY_DIR/02.pem\" &&\n" +
+		"printf 'V\\t20500101000000Z\\t\\t02\\tunknown\\t/CN=%s\\n' \"$1\" >> \"$KEY_DIR/index.txt\"\n"
+	openssl := "#!/bin/sh\n" +
+		"case \"$1 $2\" in\n" +
+		"version*) echo 'OpenSSL 1.0.2k  26 Jan 2017' ;;\n" +
+		"'ca -revoke') awk 'BEGIN { FS = OFS = \"\\t\" } $1 == \"V\" && $4 == \"01\" { $1 = \"R\"; $3 = \"200101000000Z\" } { print }' " +
+		"\"$KEY_DIR/index.txt\" > \"$KEY_DIR/index.tmp\" && mv \"$KEY_DIR/index.tmp\" \"$KEY_DIR/index.txt\" ;;\n" +
+		"'ca -gencrl') touch \"$KEY_DIR/crl.pem\" ;;\n" +
+		"esac\n"
+	if err := ioutil.WriteFile(path.Join(bin, "pkitool"), []byte(pkitool), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path.Join(bin, "openssl"), []byte(openssl), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", bin + string(os.PathListSeparator) + os.Getenv("PATH"))
+	rsa := getInstance()
+	rsa.BinDir = bin
+	rsa.KeyDir = keys
+	return rsa
+}
+
+// Index, client key files and registry
+func provisionSnapshot(rsa EasyRSA) string {
+	var state []string
+	for _, file := range []string{rsa.IndexFile(), rsa.Registry().File, path.Join(rsa.KeysDir(), "ivan.crt"),
+		path.Join(rsa.KeysDir(), "ivan.key"), path.Join(rsa.KeysDir(), "02.pem")} {
+		data, err := ioutil.ReadFile(file)
+		state = append(state, file + ": " + string(data) + fmt.Sprint(os.IsNotExist(err)))
+	}
+	return strings.Join(state, "\n")
+}
+
+func TestProvisionClientRollback(t *testing.T) {
+	dir, err := ioutil.TempDir("", "provision")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	rsa := getFakeProvisionRSA(t, dir)
+	if err = ioutil.WriteFile(rsa.IndexFile(), []byte("V\t20500101000000Z\t\t01\tunknown\t/CN=petr\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err = rsa.Registry().Put(ClientRecord{Name: "petr", Status: "valid"}); err != nil {
+		t.Fatal(err)
+	}
+	before := provisionSnapshot(rsa)
+	_, err = rsa.ProvisionClient("ivan", func(keys ClientKeyFiles) error {
+		if _, err := os.Stat(keys.Files.Certificate); err != nil {
+			t.Error("Keys not issued before use", err)
+		}
+		return errors.New("disk full")
+	})
+	if err == nil || err.Error() != "disk full" {
+		t.Fatal("Error of use must be returned", err)
+	}
+	if after := provisionSnapshot(rsa); after != before {
+		t.Error("State not restored:\n" + before + "\n---\n" + after)
+	}
+	if _, err = rsa.ProvisionClient("ivan", nil); err != nil {
+		t.Fatal("Retry provisioning", err)
+	}
+	if rec, ok, _ := rsa.Registry().Get("ivan"); !ok || rec.Status != "valid" {
+		t.Error("Client not registered", rec)
+	}
+}
+
+func TestProvisionReissueRollback(t *testing.T) {
+	dir, err := ioutil.TempDir("", "provision")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	rsa := getFakeProvisionRSA(t, dir)
+	rsa.Reissue = ReissueRevoke
+	writeTestCertSerial(t, rsa.KeysDir(), "ivan", time.Now().Add(time.Hour), 1)
+	if err = ioutil.WriteFile(rsa.IndexFile(), []byte("V\t20500101000000Z\t\t01\tunknown\t/CN=ivan\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err = rsa.Registry().Put(ClientRecord{Name: "ivan", Owner: "Ivan", Status: "valid"}); err != nil {
+		t.Fatal(err)
+	}
+	_, err = rsa.ProvisionClient("ivan", func(keys ClientKeyFiles) error {
+		return errors.New("disk full")
+	})
+	if err == nil {
+		t.Fatal("Error of use must be returned")
+	}
+	entries, _ := rsa.Index()
+	if len(entries) != 1 || entries[0].Valid() {
+		t.Error("Previous certificate must stay revoked", entries)
+	}
+	rec, _, _ := rsa.Registry().Get("ivan")
+	if rec.Status != "revoked" || rec.Revoked == nil || rec.Owner != "Ivan" {
+		t.Error("Registry does not match index", rec)
+	}
+	if _, err = rsa.ProvisionClient("ivan", nil); err != nil {
+		t.Fatal("Retry provisioning", err)
+	}
+	if rec, _, _ = rsa.Registry().Get("ivan"); rec.Status != "valid" || rec.Owner != "Ivan" {
+		t.Error("Reissued client not valid", rec)
+	}
+}
+
+func TestProvisionClientConcurrentExists(t *testing.T) {
+	dir, err := ioutil.TempDir("", "provision")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	rsa := getFakeProvisionRSA(t, dir)
+	var issued string
+	_, err = rsa.ProvisionClient("ivan", func(keys ClientKeyFiles) error {
+		issued = provisionSnapshot(rsa)
+		_, err := rsa.ProvisionClient("ivan", nil)
+		var exists *ClientExistsError
+		if !errors.As(err, &exists) {
+			t.Error("Concurrent provisioning must report existing client", err)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal("Provision client", err)
+	}
+	if after := provisionSnapshot(rsa); after != issued {
+		t.Error("Certificate of other call discarded:\n" + issued + "\n---\n" + after)
+	}
+	_, err = rsa.ProvisionClient("../ivan", nil)
+	if !errors.Is(err, ErrInvalidClientName) {
+		t.Error("Invalid name not reported", err)
+	}
+	if after := provisionSnapshot(rsa); after != issued {
+		t.Error("State changed by invalid name:\n" + issued + "\n---\n" + after)
+	}
+}
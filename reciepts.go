@@ -6,7 +6,13 @@ import (
 	"path/filepath"
 	cpath "path"
 	"archive/zip"
+	"archive/tar"
+	"compress/gzip"
 	"io"
+	"errors"
+	"strings"
+	"sort"
+	"time"
 )
 
 // Get default Easy-rsa instance
@@ -23,7 +29,7 @@ func DefaultEasyRSA(server, keyDir string) EasyRSA {
 }
 
 // Create server configuration with defaults for DEBIAN systems
-// Generates config into targetDir for specified server
+// Generates config into targetDir for specified server. Existing PKI is destroyed: see EnsureSimpleDebian
 func BuildSimpleDebian(server string, targetDir string) (EasyRSA, OpenVPNServer, error) {
 	keys := path.Join(targetDir, "keys")
 	err := os.MkdirAll(keys, 0755)
@@ -34,11 +40,17 @@ func BuildSimpleDebian(server string, targetDir string) (EasyRSA, OpenVPNServer,
 	if err = easyRSA.BuildAllServerKeys(); err != nil {
 		return easyRSA, OpenVPNServer{}, err
 	}
+	configDir, err := filepath.Abs(targetDir)
+	if err != nil {
+		return easyRSA, OpenVPNServer{}, err
+	}
 	ovpn := OpenVPNServer{
+		ConfigDir:configDir,
 		ClientToClient:true,
 		Protocol:"tcp",
 		Port:1194,
 		PersistIPFile:path.Join(targetDir, "ipp.txt"),
+		CRLFile:easyRSA.CRLFile(),
 		Keys: easyRSA.KeyFiles()    }
 	if err = ovpn.BuildTLSKey(keys); err != nil {
 		return easyRSA, ovpn, err
@@ -46,54 +58,310 @@ func BuildSimpleDebian(server string, targetDir string) (EasyRSA, OpenVPNServer,
 	return easyRSA, ovpn, ovpn.InitialConfig(targetDir)
 }
 
-// Create client archive (ZIP) whith all required files: CA, cert, key and configuration
-func BuildClientArchive(name string, ovpn OpenVPNServer, rsa EasyRSA, publicAddresses ...string) (string, error) {
-	dir, err := ioutil.TempDir("", name)
+// Same as BuildSimpleDebian but existing PKI and configuration are reused: only missing keys are generated,
+// existing server.conf is read instead of overwriting (only missing crl-verify is added).
+// If force is set, everything is rebuilt by BuildSimpleDebian
+func EnsureSimpleDebian(server string, targetDir string, force bool) (EasyRSA, OpenVPNServer, error) {
+	if force {
+		return BuildSimpleDebian(server, targetDir)
+	}
+	keys := path.Join(targetDir, "keys")
+	err := os.MkdirAll(keys, 0755)
 	if err != nil {
-		return "", err
+		return EasyRSA{}, OpenVPNServer{}, err
 	}
-	defer os.RemoveAll(dir)
-	files, err := rsa.BuildClientKeys(name)
+	easyRSA := DefaultEasyRSA(server, targetDir)
+	if err = easyRSA.EnsureServerKeys(); err != nil {
+		return easyRSA, OpenVPNServer{}, err
+	}
+	conf := path.Join(targetDir, "server.conf")
+	if fileExists(conf) {
+		ovpn, err := OpenServerConf(conf)
+		if err != nil {
+			return easyRSA, ovpn, err
+		}
+		return easyRSA, ovpn, addCRLVerify(conf, &ovpn, easyRSA.CRLFile())
+	}
+	configDir, err := filepath.Abs(targetDir)
 	if err != nil {
-		return "", err
+		return easyRSA, OpenVPNServer{}, err
 	}
-	ovpn.Addresses = publicAddresses
-	err = ovpn.BuildClientConf(dir, files.Files.Certificate, files.Files.Key)
+	ovpn := OpenVPNServer{
+		ConfigDir:configDir,
+		ClientToClient:true,
+		Protocol:"tcp",
+		Port:1194,
+		PersistIPFile:path.Join(targetDir, "ipp.txt"),
+		CRLFile:easyRSA.CRLFile(),
+		Keys: easyRSA.KeyFiles()    }
+	if tlsKey := path.Join(keys, "ta.key"); fileExists(tlsKey) {
+		ovpn.TlsKey, _ = filepath.Abs(tlsKey)
+	} else if err = ovpn.BuildTLSKey(keys); err != nil {
+		return easyRSA, ovpn, err
+	}
+	return easyRSA, ovpn, ovpn.InitialConfig(targetDir)
+}
+
+// Add crl-verify to existing configuration without it (created before CRL support), so revoked clients are
+// rejected. Other directives are kept as is
+func addCRLVerify(conf string, ovpn *OpenVPNServer, crl string) error {
+	if ovpn.CRLFile != "" {
+		return nil
+	}
+	cfg, err := ReadConfig(conf)
 	if err != nil {
-		return "", err
+		return err
+	}
+	cfg.Set("crl-verify", crl)
+	ovpn.CRLFile = crl
+	return cfg.Save(conf)
+}
+
+// Client description based on certificates index and static IPs
+type ClientInfo struct {
+	Name       string     `json:"name"`
+	Status     string     `json:"status"` // valid, revoked or expired
+	Serial     string     `json:"serial,omitempty"`
+	Expires    time.Time  `json:"expires"`
+	Revoked    *time.Time `json:"revoked,omitempty"`
+	StaticIP   string     `json:"static_ip,omitempty"`
+	StaticIPv6 string     `json:"static_ipv6,omitempty"`
+	Owner      string     `json:"owner,omitempty"` // Metadata from Registry
+	Email      string     `json:"email,omitempty"`
+	Team       string     `json:"team,omitempty"`
+	Notes      string     `json:"notes,omitempty"`
+}
+
+// List all clients from certificates index (server certificate is excluded) sorted by name.
+// Static IPs are read from PersistIPFile if it is set, metadata - from Registry
+func ListClients(ovpn OpenVPNServer, rsa EasyRSA) ([]ClientInfo, error) {
+	entries, err := rsa.Index()
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	ips := make(map[string]StaticIP)
+	if ovpn.PersistIPFile != "" {
+		entries, err := ovpn.StaticIPs()
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		for _, entry := range entries {
+			ips[entry.Client] = entry
+		}
+	}
+	records, err := rsa.Registry().Records()
+	if err != nil {
+		return nil, err
+	}
+	meta := make(map[string]ClientRecord)
+	for _, rec := range records {
+		meta[rec.Name] = rec
+	}
+	// last record wins: client could be reissued after revocation
+	byName := make(map[string]ClientInfo)
+	for _, e := range entries {
+		if e.CommonName == "" || e.CommonName == rsa.Server {
+			continue
+		}
+		c := ClientInfo{
+			Name:       e.CommonName,
+			Status:     indexStatus(e),
+			Serial:     e.Serial,
+			Expires:    e.Expires,
+			StaticIP:   ips[e.CommonName].IPv4,
+			StaticIPv6: ips[e.CommonName].IPv6,
+			Owner:      meta[e.CommonName].Owner,
+			Email:      meta[e.CommonName].Email,
+			Team:       meta[e.CommonName].Team,
+			Notes:      meta[e.CommonName].Notes,
+		}
+		if !e.Revoked.IsZero() {
+			revoked := e.Revoked
+			c.Revoked = &revoked
+		}
+		byName[e.CommonName] = c
+	}
+	var clients []ClientInfo
+	for _, c := range byName {
+		clients = append(clients, c)
+	}
+	sort.Slice(clients, func(i, j int) bool { return clients[i].Name < clients[j].Name })
+	return clients, nil
+}
+
+// Format of client bundle
+type ArchiveFormat int
+
+const (
+	ArchiveZip    ArchiveFormat = iota // ZIP archive with configuration and key files
+	ArchiveTarGz                       // Gzipped TAR archive with configuration and key files
+	ArchiveInline                      // Single .ovpn file with embedded keys
+)
+
+// Typical file extension (with dot) for bundle in this format
+func (af ArchiveFormat) Extension() string {
+	switch af {
+	case ArchiveTarGz: return ".tar.gz"
+	case ArchiveInline: return ".ovpn"
+	default: return ".zip"
+	}
+}
+
+// MIME type of bundle in this format
+func (af ArchiveFormat) ContentType() string {
+	switch af {
+	case ArchiveTarGz: return "application/gzip"
+	case ArchiveInline: return "application/x-openvpn-profile"
+	default: return "application/zip"
+	}
+}
+
+// Parse archive format by name or extension: zip, tar.gz (tgz), ovpn (inline). Empty name means ZIP
+func ParseArchiveFormat(name string) (ArchiveFormat, error) {
+	switch strings.TrimPrefix(strings.ToLower(strings.TrimSpace(name)), ".") {
+	case "", "zip": return ArchiveZip, nil
+	case "tar.gz", "tgz": return ArchiveTarGz, nil
+	case "ovpn", "inline": return ArchiveInline, nil
 	}
+	return ArchiveZip, errors.New("Unknown archive format " + name)
+}
+
+// Create client archive (ZIP) whith all required files: CA, cert, key and configuration.
+// Returns location of temporary file: caller should remove it after use
+func BuildClientArchive(name string, ovpn OpenVPNServer, rsa EasyRSA, publicAddresses ...string) (string, error) {
 	f, err := ioutil.TempFile("", name)
 	if err != nil {
 		return "", err
 	}
 	defer f.Close()
-	arch := zip.NewWriter(f)
-	defer arch.Close()
+	if err = WriteClientArchive(f, ArchiveZip, name, ovpn, rsa, publicAddresses...); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
+
+// Same as BuildClientArchive but configuration is generated for specified client platform
+func BuildPlatformClientArchive(name string, platform Platform, ovpn OpenVPNServer, rsa EasyRSA, publicAddresses ...string) (string, error) {
+	ovpn.ClientPlatform = platform
+	return BuildClientArchive(name, ovpn, rsa, publicAddresses...)
+}
+
+// Generate keys for new client and write client bundle in specified format to w.
+// If bundle could not be written, issued keys are discarded (see ProvisionClient)
+func WriteClientArchive(w io.Writer, format ArchiveFormat, name string, ovpn OpenVPNServer, rsa EasyRSA, publicAddresses ...string) error {
+	ovpn.Addresses = publicAddresses
+	if err := ovpn.checkClientFields(); err != nil {
+		return err
+	}
+	_, err := rsa.ProvisionClient(name, func(files ClientKeyFiles) error {
+		return WriteClientBundle(w, format, ovpn, files)
+	})
+	return err
+}
+
+// Write bundle in specified format for already generated client keys to w. Archives contain
+// single directory named as client
+func WriteClientBundle(w io.Writer, format ArchiveFormat, ovpn OpenVPNServer, files ClientKeyFiles) error {
+	if format == ArchiveInline {
+		return ovpn.WriteInlineClientConf(w, files.Files.Certificate, files.Files.Key)
+	}
+	dir, err := ioutil.TempDir("", files.Name)
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	err = ovpn.BuildClientConf(dir, files.Files.Certificate, files.Files.Key)
+	if err != nil {
+		return err
+	}
+	var arch archiveWriter
+	switch format {
+	case ArchiveZip: arch = &zipArchive{zip.NewWriter(w)}
+	case ArchiveTarGz: arch = newTarGzArchive(w)
+	default: return errors.New("Unknown archive format")
+	}
 	err = filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
 		if !info.IsDir() {
 			rel, err := filepath.Rel(dir, path)
 			if err != nil {
 				return err
 			}
-			wr, err := arch.Create(cpath.Join(name, rel))
-			if err != nil {
-				return err
-			}
 			f, err := os.Open(path)
 			if err != nil {
 				return err
 			}
 			defer f.Close()
-			_, err = io.Copy(wr, f)
-			return err
+			return arch.Add(cpath.Join(files.Name, rel), info, f)
 		}
 		return nil
 	})
 	if err != nil {
-		f.Close()
-		os.Remove(f.Name())
-		return "", err
+		arch.Close()
+		return err
 	}
+	return arch.Close()
+}
 
-	return f.Name(), nil
-}
\ No newline at end of file
+type archiveWriter interface {
+	Add(name string, info os.FileInfo, content io.Reader) error
+	Close() error
+}
+
+type zipArchive struct {
+	writer *zip.Writer
+}
+
+func (za *zipArchive) Add(name string, info os.FileInfo, content io.Reader) error {
+	header, err := zip.FileInfoHeader(info)
+	if err != nil {
+		return err
+	}
+	header.Name = name
+	header.Method = zip.Deflate
+	wr, err := za.writer.CreateHeader(header)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(wr, content)
+	return err
+}
+
+func (za *zipArchive) Close() error {
+	return za.writer.Close()
+}
+
+type tarGzArchive struct {
+	gz     *gzip.Writer
+	writer *tar.Writer
+}
+
+func newTarGzArchive(w io.Writer) *tarGzArchive {
+	gz := gzip.NewWriter(w)
+	return &tarGzArchive{gz: gz, writer: tar.NewWriter(gz)}
+}
+
+func (ta *tarGzArchive) Add(name string, info os.FileInfo, content io.Reader) error {
+	header, err := tar.FileInfoHeader(info, "")
+	if err != nil {
+		return err
+	}
+	header.Name = name
+	if err = ta.writer.WriteHeader(header); err != nil {
+		return err
+	}
+	_, err = io.Copy(ta.writer, content)
+	return err
+}
+
+func (ta *tarGzArchive) Close() error {
+	if err := ta.writer.Close(); err != nil {
+		ta.gz.Close()
+		return err
+	}
+	return ta.gz.Close()
+}
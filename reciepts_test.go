@@ -3,6 +3,14 @@ import (
 	"testing"
 	"os"
 	"path"
+	"io/ioutil"
+	"archive/zip"
+	"archive/tar"
+	"compress/gzip"
+	"sort"
+	"reflect"
+	"bytes"
+	"io"
 )
 
 const testReceiptsDir = "test/reciepts"
@@ -49,3 +57,158 @@ func TestBuildSimpleClient(t *testing.T) {
 	t.Log("Archive created in", archive)
 
 }
+
+func TestClientArchiveContent(t *testing.T) {
+	dir, err := ioutil.TempDir("", "archive")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ovpn := getFakeOVPNServer(t, dir)
+	keys := ClientKeyFiles{Name: "ivan", Files: KeyPair{Certificate: path.Join(dir, "ivan.crt"), Key: path.Join(dir, "ivan.key")}}
+	expected := []string{"ivan/ca.crt", "ivan/client.conf", "ivan/ivan.crt", "ivan/ivan.key", "ivan/ta.key"}
+
+	buf := &bytes.Buffer{}
+	if err = WriteClientBundle(buf, ArchiveZip, ovpn, keys); err != nil {
+		t.Fatal("Build client zip archive", err)
+	}
+	arch, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal("Open client zip archive", err)
+	}
+	var names []string
+	for _, f := range arch.File {
+		names = append(names, f.Name)
+	}
+	sort.Strings(names)
+	if !reflect.DeepEqual(names, expected) {
+		t.Error("Unexpected zip archive content", names)
+	}
+
+	buf.Reset()
+	if err = WriteClientBundle(buf, ArchiveTarGz, ovpn, keys); err != nil {
+		t.Fatal("Build client tar.gz archive", err)
+	}
+	gz, err := gzip.NewReader(buf)
+	if err != nil {
+		t.Fatal("Open client tar.gz archive", err)
+	}
+	tr := tar.NewReader(gz)
+	names = nil
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal("Read client tar.gz archive", err)
+		}
+		names = append(names, header.Name)
+	}
+	sort.Strings(names)
+	if !reflect.DeepEqual(names, expected) {
+		t.Error("Unexpected tar.gz archive content", names)
+	}
+
+	buf.Reset()
+	if err = WriteClientBundle(buf, ArchiveInline, ovpn, keys); err != nil {
+		t.Fatal("Build client inline config", err)
+	}
+	if !bytes.Contains(buf.Bytes(), []byte("<ca>")) {
+		t.Error("Inline config has no CA")
+	}
+}
+
+func TestEnsureSimpleDebianReuse(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ensure")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	rsa := DefaultEasyRSA("test.local", dir)
+	keys := rsa.KeyFiles()
+	for _, file := range []string{keys.CA.Certificate, keys.CA.Key, keys.Server.Certificate, keys.Server.Key, keys.DiffieHellman, rsa.CRLFile()} {
+		if err = ioutil.WriteFile(file, []byte("existing"), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	conf := "port 443\nproto udp\nca " + keys.CA.Certificate + "\nifconfig-pool-persist " + path.Join(dir, "ipp.txt") + "\n" +
+		"crl-verify " + rsa.CRLFile() + "\n"
+	if err = ioutil.WriteFile(path.Join(dir, "server.conf"), []byte(conf), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err = ioutil.WriteFile(path.Join(dir, "ipp.txt"), []byte("ivan,10.8.0.4\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	_, ovpn, err := EnsureSimpleDebian("test.local", dir, false)
+	if err != nil {
+		t.Fatal("Ensure existing server", err)
+	}
+	if ovpn.Port != 443 || ovpn.Protocol != "udp" {
+		t.Error("Existing configuration not reused", ovpn.Port, ovpn.Protocol)
+	}
+	if data, _ := ioutil.ReadFile(path.Join(dir, "server.conf")); string(data) != conf {
+		t.Error("Existing configuration overwritten")
+	}
+	if data, _ := ioutil.ReadFile(path.Join(dir, "ipp.txt")); string(data) != "ivan,10.8.0.4\n" {
+		t.Error("Static IPs lost")
+	}
+}
+
+func TestEnsureSimpleDebianAddsCRL(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ensure")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	rsa := DefaultEasyRSA("test.local", dir)
+	keys := rsa.KeyFiles()
+	for _, file := range []string{keys.CA.Certificate, keys.CA.Key, keys.Server.Certificate, keys.Server.Key, keys.DiffieHellman, rsa.CRLFile()} {
+		if err = ioutil.WriteFile(file, []byte("existing"), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	conf := "port 443\nproto udp\nca " + keys.CA.Certificate + "\n"
+	if err = ioutil.WriteFile(path.Join(dir, "server.conf"), []byte(conf), 0644); err != nil {
+		t.Fatal(err)
+	}
+	_, ovpn, err := EnsureSimpleDebian("test.local", dir, false)
+	if err != nil {
+		t.Fatal("Ensure server without CRL", err)
+	}
+	if ovpn.CRLFile != rsa.CRLFile() {
+		t.Error("CRL not set", ovpn.CRLFile)
+	}
+	if data, _ := ioutil.ReadFile(path.Join(dir, "server.conf")); string(data) != conf + "crl-verify " + rsa.CRLFile() + "\n" {
+		t.Error("crl-verify not added", string(data))
+	}
+}
+
+func TestRevokedClientCRL(t *testing.T) {
+	os.RemoveAll(testReceiptsDir)
+	defer os.RemoveAll(testReceiptsDir)
+	rsa, _, err := BuildSimpleDebian("test.local", testReceiptsDir)
+	if err != nil {
+		t.Fatal("Receipt: simple debian", err)
+	}
+	if _, err = rsa.BuildClientKeys("ivan"); err != nil {
+		t.Fatal("Build client keys", err)
+	}
+	if err = rsa.RevokeClient("ivan"); err != nil {
+		t.Fatal("Revoke client", err)
+	}
+	ovpn, err := OpenServerConf(path.Join(testReceiptsDir, "server.conf"))
+	if err != nil {
+		t.Fatal("Open server config", err)
+	}
+	if ovpn.CRLFile != rsa.CRLFile() {
+		t.Fatal("Server config does not verify CRL", ovpn.CRLFile)
+	}
+	crl, err := readCRL(ovpn.CRLFile)
+	if err != nil {
+		t.Fatal("Read CRL", err)
+	}
+	if len(crl.RevokedCertificateEntries) != 1 {
+		t.Error("Revoked client not in CRL", len(crl.RevokedCertificateEntries))
+	}
+}
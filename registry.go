@@ -0,0 +1,178 @@
+package vpnc
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"time"
+)
+
+// Client metadata in registry
+type ClientRecord struct {
+	Name    string     `json:"name"`
+	Owner   string     `json:"owner,omitempty"`
+	Email   string     `json:"email,omitempty"`
+	Team    string     `json:"team,omitempty"`
+	Created time.Time  `json:"created"`
+	Expires *time.Time `json:"expires,omitempty"` // Certificate expiration (nil if unknown)
+	Status  string     `json:"status"`            // valid or revoked
+	Revoked *time.Time `json:"revoked,omitempty"`
+	Notes   string     `json:"notes,omitempty"`
+}
+
+// Persistent registry of clients stored as JSON file (by default clients.json in keys directory).
+// Certificates are kept in sync by BuildClientKeys and RevokeClient, other metadata is managed by caller
+type Registry struct {
+	File string // Location of registry file
+}
+
+// Registry of clients in keys directory
+func (er EasyRSA) Registry() Registry {
+	return Registry{File: path.Join(er.KeysDir(), "clients.json")}
+}
+
+// All records sorted by name. Missing registry is empty
+func (r Registry) Records() ([]ClientRecord, error) {
+	data, err := ioutil.ReadFile(r.File)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var records []ClientRecord
+	if err = json.Unmarshal(data, &records); err != nil {
+		return nil, errors.New(r.File + ": " + err.Error())
+	}
+	return records, nil
+}
+
+// Records matched by filter
+func (r Registry) Find(match func(ClientRecord) bool) ([]ClientRecord, error) {
+	records, err := r.Records()
+	if err != nil {
+		return nil, err
+	}
+	var found []ClientRecord
+	for _, rec := range records {
+		if match(rec) {
+			found = append(found, rec)
+		}
+	}
+	return found, nil
+}
+
+// Get record by client name
+func (r Registry) Get(name string) (ClientRecord, bool, error) {
+	records, err := r.Records()
+	if err != nil {
+		return ClientRecord{}, false, err
+	}
+	for _, rec := range records {
+		if rec.Name == name {
+			return rec, true, nil
+		}
+	}
+	return ClientRecord{}, false, nil
+}
+
+// Add or replace record
+func (r Registry) Put(record ClientRecord) error {
+	return r.Update(record.Name, func(rec *ClientRecord) {
+		*rec = record
+	})
+}
+
+// Modify record of client by function. New record is created if client is not registered
+func (r Registry) Update(name string, update func(rec *ClientRecord)) error {
+	if name == "" {
+		return errors.New("Client name is required")
+	}
+	unlock, err := lockFile(r.File + ".lock")
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	records, err := r.Records()
+	if err != nil {
+		return err
+	}
+	index := -1
+	for i, rec := range records {
+		if rec.Name == name {
+			index = i
+		}
+	}
+	if index == -1 {
+		records = append(records, ClientRecord{Name: name, Created: time.Now(), Status: "valid"})
+		index = len(records) - 1
+	}
+	update(&records[index])
+	records[index].Name = name
+	return r.save(records)
+}
+
+// Remove record of client. Not registered client is not an error
+func (r Registry) Remove(name string) error {
+	unlock, err := lockFile(r.File + ".lock")
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	records, err := r.Records()
+	if err != nil {
+		return err
+	}
+	var kept []ClientRecord
+	for _, rec := range records {
+		if rec.Name != name {
+			kept = append(kept, rec)
+		}
+	}
+	if len(kept) == len(records) {
+		return nil
+	}
+	return r.save(kept)
+}
+
+func (r Registry) save(records []ClientRecord) error {
+	sort.Slice(records, func(i, j int) bool { return records[i].Name < records[j].Name })
+	if records == nil {
+		records = []ClientRecord{}
+	}
+	data, err := json.MarshalIndent(records, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(path.Dir(r.File), 0755); err != nil {
+		return err
+	}
+	return writeFileAtomic(r.File, append(data, '\n'), 0600)
+}
+
+// Mark client as (re)issued: certificate expiration is read from certificate file
+func (r Registry) issued(name, certificate string) error {
+	var expires *time.Time
+	if data, err := ioutil.ReadFile(certificate); err == nil {
+		if cert, err := parseCertificate(data); err == nil {
+			expires = &cert.NotAfter
+		}
+	}
+	return r.Update(name, func(rec *ClientRecord) {
+		rec.Created = time.Now()
+		rec.Expires = expires
+		rec.Status = "valid"
+		rec.Revoked = nil
+	})
+}
+
+// Mark client as revoked
+func (r Registry) revoked(name string) error {
+	now := time.Now()
+	return r.Update(name, func(rec *ClientRecord) {
+		rec.Status = "revoked"
+		rec.Revoked = &now
+	})
+}
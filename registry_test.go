@@ -0,0 +1,91 @@
+package vpnc
+import (
+	"testing"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+func TestRegistry(t *testing.T) {
+	dir, err := ioutil.TempDir("", "registry")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	reg := Registry{File: path.Join(dir, "clients.json")}
+	if records, err := reg.Records(); err != nil || len(records) != 0 {
+		t.Fatal("Missing registry must be empty", records, err)
+	}
+	if err = reg.Put(ClientRecord{Name: "petr", Owner: "Petr", Team: "ops"}); err != nil {
+		t.Fatal("Put", err)
+	}
+	err = reg.Update("ivan", func(rec *ClientRecord) {
+		rec.Owner = "Ivan"
+		rec.Team = "dev"
+	})
+	if err != nil {
+		t.Fatal("Update", err)
+	}
+	rec, ok, err := reg.Get("ivan")
+	if err != nil || !ok {
+		t.Fatal("Get", ok, err)
+	}
+	if rec.Owner != "Ivan" || rec.Status != "valid" || rec.Created.IsZero() {
+		t.Error("Bad record", rec)
+	}
+	if data, _ := ioutil.ReadFile(reg.File); strings.Contains(string(data), "expires") {
+		t.Error("Unknown expiration must be omitted", string(data))
+	}
+	found, err := reg.Find(func(rec ClientRecord) bool { return rec.Team == "ops" })
+	if err != nil || len(found) != 1 || found[0].Name != "petr" {
+		t.Error("Find by team", found, err)
+	}
+	if err = reg.revoked("ivan"); err != nil {
+		t.Fatal("Revoke", err)
+	}
+	rec, _, _ = reg.Get("ivan")
+	if rec.Status != "revoked" || rec.Revoked == nil || rec.Owner != "Ivan" {
+		t.Error("Revocation must keep metadata", rec)
+	}
+	if err = reg.Remove("petr"); err != nil {
+		t.Fatal("Remove", err)
+	}
+	if records, _ := reg.Records(); len(records) != 1 || records[0].Name != "ivan" {
+		t.Error("Unexpected records", records)
+	}
+}
+
+func TestRegistryIssued(t *testing.T) {
+	dir, err := ioutil.TempDir("", "registry")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	rsa := getInstance()
+	rsa.KeyDir = dir
+	expires := time.Now().Add(48 * time.Hour).Truncate(time.Second)
+	cert, _ := writeTestCert(t, dir, "ivan", expires)
+	if err = rsa.Registry().Put(ClientRecord{Name: "ivan", Owner: "Ivan", Status: "revoked"}); err != nil {
+		t.Fatal(err)
+	}
+	if err = rsa.Registry().issued("ivan", cert); err != nil {
+		t.Fatal("Issue", err)
+	}
+	index := "V\t20500101000000Z\t\t02\tunknown\t/C=RU/CN=ivan\n"
+	if err = ioutil.WriteFile(rsa.IndexFile(), []byte(index), 0644); err != nil {
+		t.Fatal(err)
+	}
+	clients, err := ListClients(OpenVPNServer{}, rsa)
+	if err != nil {
+		t.Fatal("List clients", err)
+	}
+	if len(clients) != 1 || clients[0].Owner != "Ivan" {
+		t.Error("Metadata not merged", clients)
+	}
+	rec, _, _ := rsa.Registry().Get("ivan")
+	if rec.Status != "valid" || rec.Expires == nil || !rec.Expires.Equal(expires) {
+		t.Error("Bad issued record", rec)
+	}
+}
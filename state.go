@@ -0,0 +1,424 @@
+package vpnc
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Desired state of server and its clients. Could be loaded from JSON file by LoadDesiredState
+type DesiredState struct {
+	Server  DesiredServer   `json:"server"`
+	Clients []DesiredClient `json:"clients"`
+}
+
+// Desired server settings. Zero values mean "leave as is"
+type DesiredServer struct {
+	Port            uint16   `json:"port,omitempty"`
+	Protocol        string   `json:"protocol,omitempty"`
+	ClientToClient  *bool    `json:"client_to_client,omitempty"`
+	Routes          []string `json:"routes,omitempty"`            // Networks (CIDR) pushed to all clients. Nil - leave as is
+	ClientConfigDir string   `json:"client_config_dir,omitempty"` // Directory for per-client configurations (CCD)
+}
+
+// Desired client
+type DesiredClient struct {
+	Name     string     `json:"name"`
+	StaticIP string     `json:"static_ip,omitempty"` // Static IP in PersistIPFile
+	Expires  *time.Time `json:"expires,omitempty"`   // Certificate expiration. Client is revoked after this time
+	Routes   []string   `json:"routes,omitempty"`    // Networks (CIDR) pushed only to this client by CCD file
+}
+
+// Client should exist (not expired)
+func (dc DesiredClient) Active() bool {
+	return dc.Expires == nil || time.Now().Before(*dc.Expires)
+}
+
+// Kind of reconciliation action
+const (
+	ActionIssue        = "issue"         // Issue client certificate
+	ActionRevoke       = "revoke"        // Revoke client certificate
+	ActionSetIP        = "set-ip"        // Set static IP of client
+	ActionRemoveIP     = "remove-ip"     // Remove static IP of client
+	ActionWriteCCD     = "write-ccd"     // Write per-client configuration
+	ActionRemoveCCD    = "remove-ccd"    // Remove per-client configuration
+	ActionSkipCCD      = "skip-ccd"      // Per-client configuration is not managed (no marker) and left as is
+	ActionUpdateConfig = "update-config" // Change server configuration
+)
+
+// Single step of reconciliation
+type Action struct {
+	Kind   string `json:"kind"`
+	Client string `json:"client,omitempty"`
+	Detail string `json:"detail,omitempty"`
+}
+
+func (a Action) String() string {
+	text := a.Kind
+	if a.Client != "" {
+		text += " " + a.Client
+	}
+	if a.Detail != "" {
+		text += ": " + a.Detail
+	}
+	return text
+}
+
+// Ordered list of actions required to reach desired state
+type Plan struct {
+	Actions []Action `json:"actions"`
+}
+
+// Nothing to change: plan has no actions except skipped ones
+func (p Plan) Empty() bool {
+	for _, a := range p.Actions {
+		if a.Kind != ActionSkipCCD {
+			return false
+		}
+	}
+	return true
+}
+
+func (p Plan) String() string {
+	if len(p.Actions) == 0 {
+		return "No changes\n"
+	}
+	var lines []string
+	for _, a := range p.Actions {
+		lines = append(lines, a.String())
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+func (p *Plan) add(kind, client, detail string) {
+	p.Actions = append(p.Actions, Action{Kind: kind, Client: client, Detail: detail})
+}
+
+// Read desired state from JSON file. YAML is not supported (no dependencies): convert it to JSON first
+func LoadDesiredState(file string) (DesiredState, error) {
+	var state DesiredState
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return state, err
+	}
+	if err = json.Unmarshal(data, &state); err != nil {
+		return state, errors.New(file + ": " + err.Error())
+	}
+	return state, state.Validate()
+}
+
+// Check client names, addresses and routes
+func (ds DesiredState) Validate() error {
+	names := make(map[string]bool)
+	for _, c := range ds.Clients {
+		// name is used for CCD file and certificate
+		if err := ValidateClientName(c.Name); err != nil {
+			return err
+		}
+		if names[c.Name] {
+			return errors.New("Duplicated client " + c.Name)
+		}
+		names[c.Name] = true
+		if c.StaticIP != "" && net.ParseIP(c.StaticIP) == nil {
+			return fmt.Errorf("%w: %q of client %s", ErrInvalidIP, c.StaticIP, c.Name)
+		}
+		for _, route := range c.Routes {
+			if _, _, err := net.ParseCIDR(route); err != nil {
+				return errors.New("Client " + c.Name + ": " + err.Error())
+			}
+		}
+	}
+	for _, route := range ds.Server.Routes {
+		if _, _, err := net.ParseCIDR(route); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Compare desired state with PKI inventory, static IPs, CCD files and server configuration
+func PlanState(state DesiredState, serverConf string, rsa EasyRSA) (Plan, error) {
+	return ApplyState(state, serverConf, rsa, true)
+}
+
+// Reconcile server with desired state: issue missing certificates, revoke removed or expired clients,
+// rewrite static IPs, CCD files and server configuration. CCD files without ccdMarker are left as is (ActionSkipCCD). In dry run mode nothing is changed.
+// Returns plan of (applied) actions. On error returned plan contains actions applied so far
+func ApplyState(state DesiredState, serverConf string, rsa EasyRSA, dryRun bool) (Plan, error) {
+	var plan Plan
+	if err := state.Validate(); err != nil {
+		return plan, err
+	}
+	cfg, err := ReadConfig(serverConf)
+	if err != nil {
+		return plan, err
+	}
+	// server configuration first: CCD location could be changed
+	if changes := state.Server.apply(cfg); len(changes) > 0 {
+		plan.add(ActionUpdateConfig, "", strings.Join(changes, ", "))
+		if !dryRun {
+			if err = cfg.Save(serverConf); err != nil {
+				return plan, err
+			}
+		}
+	}
+	ovpn, err := ServerFromConfig(cfg)
+	if err != nil {
+		return plan, err
+	}
+	ovpn.PersistIPFile = resolveConfPath(serverConf, ovpn.PersistIPFile)
+	ovpn.ClientConfigDir = resolveConfPath(serverConf, ovpn.ClientConfigDir)
+
+	clients, err := ListClients(OpenVPNServer{}, rsa)
+	if err != nil {
+		return plan, err
+	}
+	valid := make(map[string]bool)
+	for _, c := range clients {
+		valid[c.Name] = c.Status == "valid"
+	}
+	desired := make(map[string]DesiredClient)
+	for _, c := range state.Clients {
+		if c.Active() {
+			desired[c.Name] = c
+		}
+	}
+
+	// certificates
+	for _, c := range state.Clients {
+		if !c.Active() || valid[c.Name] {
+			continue
+		}
+		plan.add(ActionIssue, c.Name, "")
+		if !dryRun {
+			issuer := rsa
+			if c.Expires != nil {
+				issuer.KeyExpire = int(math.Ceil(time.Until(*c.Expires).Hours() / 24))
+			}
+			if _, err = issuer.BuildClientKeys(c.Name); err != nil {
+				return plan, err
+			}
+		}
+	}
+	for _, c := range clients {
+		if _, ok := desired[c.Name]; ok || c.Status != "valid" {
+			continue
+		}
+		plan.add(ActionRevoke, c.Name, "")
+		if !dryRun {
+			if err = rsa.RevokeClient(c.Name); err != nil {
+				return plan, err
+			}
+		}
+	}
+
+	// static IPs
+	if ovpn.PersistIPFile != "" {
+		entries, err := ovpn.StaticIPs()
+		if err != nil && !os.IsNotExist(err) {
+			return plan, err
+		}
+		ips := make(map[string]string)
+		ipv6 := make(map[string]string)
+		var names []string
+		for _, entry := range entries {
+			ips[entry.Client], ipv6[entry.Client] = entry.IPv4, entry.IPv6
+			names = append(names, entry.Client)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if c, ok := desired[name]; !ok || c.StaticIP == "" {
+				plan.add(ActionRemoveIP, name, ips[name])
+				if !dryRun {
+					if err = ovpn.RemoveStaticIP(name); err != nil {
+						return plan, err
+					}
+				}
+			}
+		}
+		for _, c := range state.Clients {
+			if !c.Active() || c.StaticIP == "" || ips[c.Name] == c.StaticIP {
+				continue
+			}
+			plan.add(ActionSetIP, c.Name, c.StaticIP)
+			if !dryRun {
+				if err = ovpn.SetStaticIP(StaticIP{Client: c.Name, IPv4: c.StaticIP, IPv6: ipv6[c.Name]}); err != nil {
+					return plan, err
+				}
+			}
+		}
+	} else {
+		for _, c := range state.Clients {
+			if c.Active() && c.StaticIP != "" {
+				return plan, errors.New("Static IP of " + c.Name + " requires ifconfig-pool-persist in server configuration")
+			}
+		}
+	}
+
+	// per-client configurations
+	if ovpn.ClientConfigDir != "" {
+		if err = applyCCD(&plan, ovpn.ClientConfigDir, state, desired, dryRun); err != nil {
+			return plan, err
+		}
+	}
+	return plan, nil
+}
+
+// First line of CCD files written by ApplyState. Files without it are never changed or removed
+const ccdMarker = "# Managed by vpn-control"
+
+func applyCCD(plan *Plan, dir string, state DesiredState, desired map[string]DesiredClient, dryRun bool) error {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for _, f := range files {
+		if c, ok := desired[f.Name()]; f.IsDir() || (ok && len(c.Routes) > 0) {
+			continue
+		}
+		file := filepath.Join(dir, f.Name())
+		managed, err := isManagedCCD(file)
+		if err != nil {
+			return err
+		}
+		if !managed {
+			plan.add(ActionSkipCCD, f.Name(), "not managed by vpn-control")
+			continue
+		}
+		plan.add(ActionRemoveCCD, f.Name(), "")
+		if !dryRun {
+			if err = os.Remove(file); err != nil {
+				return err
+			}
+		}
+	}
+	for _, c := range state.Clients {
+		if !c.Active() || len(c.Routes) == 0 {
+			continue
+		}
+		content := ccdMarker + "\n"
+		for _, route := range c.Routes {
+			content += "push \"" + pushRoute(route) + "\"\n"
+		}
+		file := filepath.Join(dir, c.Name)
+		current, err := ioutil.ReadFile(file)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		if err == nil && string(current) == content {
+			continue
+		}
+		if err == nil && !strings.HasPrefix(string(current), ccdMarker + "\n") {
+			plan.add(ActionSkipCCD, c.Name, "not managed by vpn-control, routes " + strings.Join(c.Routes, " ") + " not written")
+			continue
+		}
+		plan.add(ActionWriteCCD, c.Name, strings.Join(c.Routes, " "))
+		if !dryRun {
+			if err = os.MkdirAll(dir, 0755); err != nil {
+				return err
+			}
+			if err = writeFileAtomic(file, []byte(content), 0644); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// CCD file starts with ccdMarker line
+func isManagedCCD(file string) (bool, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return false, err
+	}
+	return strings.HasPrefix(string(data), ccdMarker + "\n"), nil
+}
+
+// Apply server settings to configuration. Returns description of changes
+func (ds DesiredServer) apply(cfg *Config) []string {
+	var changes []string
+	set := func(name string, args ...string) {
+		current := cfg.Get(name)
+		if current != nil && current.Kind == NodeDirective && strings.Join(current.Args, " ") == strings.Join(args, " ") {
+			return
+		}
+		cfg.Set(name, args...)
+		changes = append(changes, name + " " + strings.Join(args, " "))
+	}
+	if ds.Port != 0 {
+		set("port", strconv.Itoa(int(ds.Port)))
+	}
+	if ds.Protocol != "" {
+		set("proto", ds.Protocol)
+	}
+	if ds.ClientConfigDir != "" {
+		set("client-config-dir", ds.ClientConfigDir)
+	}
+	if ds.ClientToClient != nil && *ds.ClientToClient != cfg.Has("client-to-client") {
+		if *ds.ClientToClient {
+			cfg.Add("client-to-client")
+			changes = append(changes, "client-to-client")
+		} else {
+			cfg.Remove("client-to-client")
+			changes = append(changes, "no client-to-client")
+		}
+	}
+	if ds.Routes != nil {
+		var current, wanted []string
+		for _, node := range cfg.GetAll("push") {
+			if isPushRoute(node) {
+				current = append(current, node.Args[0])
+			}
+		}
+		for _, route := range ds.Routes {
+			wanted = append(wanted, pushRoute(route))
+		}
+		if strings.Join(current, "\n") != strings.Join(wanted, "\n") {
+			var nodes []*Node
+			for _, node := range cfg.Nodes {
+				if node.Kind == NodeDirective && node.Name == "push" && isPushRoute(node) {
+					continue
+				}
+				nodes = append(nodes, node)
+			}
+			cfg.Nodes = nodes
+			for _, route := range wanted {
+				cfg.Add("push", route)
+			}
+			changes = append(changes, "push routes " + strings.Join(ds.Routes, " "))
+		}
+	}
+	return changes
+}
+
+// Convert CIDR to pushed route option: 192.168.1.0/24 -> route 192.168.1.0 255.255.255.0, fd00::/64 -> route-ipv6 fd00::/64
+func pushRoute(cidr string) string {
+	_, network, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return "route " + cidr
+	}
+	if len(network.Mask) != net.IPv4len {
+		return "route-ipv6 " + network.String()
+	}
+	return "route " + network.IP.String() + " " + net.IP(network.Mask).String()
+}
+
+func isPushRoute(node *Node) bool {
+	return len(node.Args) > 0 && (strings.HasPrefix(node.Args[0], "route ") || strings.HasPrefix(node.Args[0], "route-ipv6 "))
+}
+
+// Resolve path from server configuration relative to its directory
+func resolveConfPath(serverConf, file string) string {
+	if file == "" || filepath.IsAbs(file) {
+		return file
+	}
+	return filepath.Join(filepath.Dir(serverConf), file)
+}
@@ -0,0 +1,193 @@
+package vpnc
+import (
+	"testing"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+func prepareTestState(t *testing.T) (string, EasyRSA) {
+	dir, err := ioutil.TempDir("", "state")
+	if err != nil {
+		t.Fatal(err)
+	}
+	conf := "port 1194\nproto udp\nserver 10.8.0.0 255.255.255.0\n# keep me\nifconfig-pool-persist ipp.txt\npush \"route 10.0.0.0 255.0.0.0\"\n"
+	if err = ioutil.WriteFile(path.Join(dir, "server.conf"), []byte(conf), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err = ioutil.WriteFile(path.Join(dir, "ipp.txt"), []byte("ivan,10.8.0.4\npetr,10.8.0.8\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	index := "V\t20500101000000Z\t\t01\tunknown\t/C=RU/CN=test.local\n" +
+		"V\t20500101000000Z\t\t02\tunknown\t/C=RU/CN=ivan\n" +
+		"V\t20500101000000Z\t\t03\tunknown\t/C=RU/CN=petr\n"
+	if err = ioutil.WriteFile(path.Join(dir, "index.txt"), []byte(index), 0644); err != nil {
+		t.Fatal(err)
+	}
+	rsa := getInstance()
+	rsa.KeyDir = dir
+	return dir, rsa
+}
+
+func TestPlanState(t *testing.T) {
+	dir, rsa := prepareTestState(t)
+	defer os.RemoveAll(dir)
+	expired := time.Now().Add(-time.Hour)
+	state := DesiredState{
+		Server: DesiredServer{Port: 443, Protocol: "tcp", Routes: []string{"192.168.1.0/24"}, ClientConfigDir: "ccd"},
+		Clients: []DesiredClient{
+			{Name: "ivan", StaticIP: "10.8.0.12", Routes: []string{"172.16.0.0/16"}},
+			{Name: "petr", Expires: &expired},
+			{Name: "anna"},
+		},
+	}
+	confBefore, _ := ioutil.ReadFile(path.Join(dir, "server.conf"))
+	plan, err := PlanState(state, path.Join(dir, "server.conf"), rsa)
+	if err != nil {
+		t.Fatal("Plan state", err)
+	}
+	t.Log(plan)
+	expected := []string{
+		"update-config: port 443, proto tcp, client-config-dir ccd, push routes 192.168.1.0/24",
+		"issue anna",
+		"revoke petr",
+		"remove-ip petr: 10.8.0.8",
+		"set-ip ivan: 10.8.0.12",
+		"write-ccd ivan: 172.16.0.0/16",
+	}
+	if plan.String() != strings.Join(expected, "\n") + "\n" {
+		t.Error("Unexpected plan:\n" + plan.String())
+	}
+	confAfter, _ := ioutil.ReadFile(path.Join(dir, "server.conf"))
+	if string(confBefore) != string(confAfter) {
+		t.Error("Dry run must not change configuration")
+	}
+}
+
+func TestApplyState(t *testing.T) {
+	dir, rsa := prepareTestState(t)
+	defer os.RemoveAll(dir)
+	state := DesiredState{
+		Server: DesiredServer{Port: 443, Routes: []string{"192.168.1.0/24"}, ClientConfigDir: "ccd"},
+		Clients: []DesiredClient{
+			{Name: "ivan", StaticIP: "10.8.0.12", Routes: []string{"172.16.0.0/16"}},
+			{Name: "petr"},
+		},
+	}
+	plan, err := ApplyState(state, path.Join(dir, "server.conf"), rsa, false)
+	if err != nil {
+		t.Fatal("Apply state", err)
+	}
+	if len(plan.Actions) != 4 {
+		t.Error("Unexpected plan:\n" + plan.String())
+	}
+	conf, _ := ioutil.ReadFile(path.Join(dir, "server.conf"))
+	if string(conf) != "port 443\nproto udp\nserver 10.8.0.0 255.255.255.0\n# keep me\nifconfig-pool-persist ipp.txt\nclient-config-dir ccd\npush \"route 192.168.1.0 255.255.255.0\"\n" {
+		t.Error("Unexpected server config:\n" + string(conf))
+	}
+	ccd, _ := ioutil.ReadFile(path.Join(dir, "ccd", "ivan"))
+	if !strings.Contains(string(ccd), "push \"route 172.16.0.0 255.255.0.0\"") {
+		t.Error("Unexpected CCD:\n" + string(ccd))
+	}
+	ovpn := OpenVPNServer{PersistIPFile: path.Join(dir, "ipp.txt")}
+	ips, _ := ovpn.ListStaticIP()
+	if len(ips) != 1 || ips["ivan"] != "10.8.0.12" {
+		t.Error("Unexpected static IPs", ips)
+	}
+	// second apply has nothing to do
+	plan, err = PlanState(state, path.Join(dir, "server.conf"), rsa)
+	if err != nil {
+		t.Fatal("Plan state", err)
+	}
+	if !plan.Empty() {
+		t.Error("State must be reconciled:\n" + plan.String())
+	}
+}
+
+func TestDesiredStateValidate(t *testing.T) {
+	bad := []DesiredState{
+		{Clients: []DesiredClient{{Name: ""}}},
+		{Clients: []DesiredClient{{Name: "ivan"}, {Name: "ivan"}}},
+		{Clients: []DesiredClient{{Name: "ivan", StaticIP: "10.8.0"}}},
+		{Clients: []DesiredClient{{Name: "ivan", Routes: []string{"10.0.0.0"}}}},
+		{Server: DesiredServer{Routes: []string{"bad"}}},
+	}
+	for _, state := range bad {
+		if state.Validate() == nil {
+			t.Error("Invalid state passed validation", state)
+		}
+	}
+	for _, name := range []string{"", "../x", "a/b"} {
+		state := DesiredState{Clients: []DesiredClient{{Name: name}}}
+		if err := state.Validate(); !errors.Is(err, ErrInvalidClientName) {
+			t.Error("Unsafe client name passed validation", name, err)
+		}
+	}
+	state := DesiredState{Clients: []DesiredClient{{Name: "ivan", StaticIP: "10.8.0"}}}
+	if err := state.Validate(); !errors.Is(err, ErrInvalidIP) {
+		t.Error("Invalid static IP not reported as ErrInvalidIP", err)
+	}
+}
+
+func TestApplyStateKeepsUnmanagedCCD(t *testing.T) {
+	dir, rsa := prepareTestState(t)
+	defer os.RemoveAll(dir)
+	ccd := path.Join(dir, "ccd")
+	if err := os.MkdirAll(ccd, 0755); err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{
+		"anna": "ifconfig-push 10.8.0.20 255.255.255.0\n",
+		"ivan": "iroute 192.168.5.0 255.255.255.0\n",
+		"petr": "push \"route 172.17.0.0 255.255.0.0\"\n",
+		"olga": "# Managed by vpn-control\npush \"route 172.18.0.0 255.255.0.0\"\n",
+	}
+	for name, content := range files {
+		if err := ioutil.WriteFile(path.Join(ccd, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	state := DesiredState{
+		Server: DesiredServer{ClientConfigDir: "ccd"},
+		Clients: []DesiredClient{
+			{Name: "ivan", StaticIP: "10.8.0.4", Routes: []string{"172.16.0.0/16"}},
+			{Name: "petr", StaticIP: "10.8.0.8"},
+		},
+	}
+	plan, err := ApplyState(state, path.Join(dir, "server.conf"), rsa, false)
+	if err != nil {
+		t.Fatal("Apply state", err)
+	}
+	expected := []string{
+		"update-config: client-config-dir ccd",
+		"skip-ccd anna: not managed by vpn-control",
+		"remove-ccd olga",
+		"skip-ccd petr: not managed by vpn-control",
+		"skip-ccd ivan: not managed by vpn-control, routes 172.16.0.0/16 not written",
+	}
+	if plan.String() != strings.Join(expected, "\n") + "\n" {
+		t.Error("Unexpected plan:\n" + plan.String())
+	}
+	for name, content := range files {
+		data, err := ioutil.ReadFile(path.Join(ccd, name))
+		if name == "olga" {
+			if !os.IsNotExist(err) {
+				t.Error("Managed CCD of removed client not removed")
+			}
+			continue
+		}
+		if string(data) != content {
+			t.Error("Unmanaged CCD changed", name, string(data))
+		}
+	}
+	plan, err = PlanState(state, path.Join(dir, "server.conf"), rsa)
+	if err != nil {
+		t.Fatal("Plan state", err)
+	}
+	if !plan.Empty() || len(plan.Actions) != 3 {
+		t.Error("Only skipped files must be left:\n" + plan.String())
+	}
+}
@@ -0,0 +1,250 @@
+package vpnc
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Connected client from OpenVPN status file
+type StatusClient struct {
+	CommonName         string
+	RealAddress        string    // Public address with port: 1.2.3.4:51234
+	VirtualAddress     string    // Assigned IPv4 address. Could be empty
+	VirtualIPv6Address string    // Assigned IPv6 address. Could be empty
+	BytesReceived      uint64    // Bytes received by server from client
+	BytesSent          uint64    // Bytes sent by server to client
+	ConnectedSince     time.Time
+	Username           string    // Username for auth-user-pass clients (status version 2 and 3). Could be UNDEF
+}
+
+// Routing table entry from OpenVPN status file
+type StatusRoute struct {
+	VirtualAddress string
+	CommonName     string
+	RealAddress    string
+	LastRef        time.Time
+}
+
+// Parsed OpenVPN status file
+type Status struct {
+	Version     int               // Detected format version: 1, 2 or 3
+	Title       string            // OpenVPN version (status version 2 and 3)
+	Updated     time.Time         // Time of status file update
+	Clients     []StatusClient
+	Routes      []StatusRoute
+	GlobalStats map[string]string // Global statistic like "Max bcast/mcast queue length"
+}
+
+// Find connected client by common name. Returns nil if not connected
+func (st *Status) Client(commonName string) *StatusClient {
+	for i := range st.Clients {
+		if st.Clients[i].CommonName == commonName {
+			return &st.Clients[i]
+		}
+	}
+	return nil
+}
+
+// Read and parse status file of server (see StatusPath)
+func (ovpn OpenVPNServer) ReadStatus() (*Status, error) {
+	return ReadStatus(ovpn.StatusPath())
+}
+
+// Read and parse OpenVPN status file
+func ReadStatus(file string) (*Status, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ParseStatus(f)
+}
+
+// Parse OpenVPN status file of version 1, 2 or 3 (detected automatically)
+func ParseStatus(r io.Reader) (*Status, error) {
+	scanner := bufio.NewScanner(r)
+	var lines []string
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(lines) == 0 {
+		return nil, errors.New("Empty status file")
+	}
+	var st *Status
+	var err error
+	switch {
+	case lines[0] == "OpenVPN CLIENT LIST":
+		st, err = parseStatusV1(lines)
+	case strings.HasPrefix(lines[0], "TITLE,") || strings.HasPrefix(lines[0], "TIME,"):
+		st, err = parseStatusV23(lines, ",", 2)
+	case strings.HasPrefix(lines[0], "TITLE\t") || strings.HasPrefix(lines[0], "TIME\t"):
+		st, err = parseStatusV23(lines, "\t", 3)
+	default:
+		return nil, errors.New("Unknown status file format")
+	}
+	if err != nil {
+		return nil, err
+	}
+	st.fillVirtualAddresses()
+	return st, nil
+}
+
+func parseStatusV1(lines []string) (*Status, error) {
+	st := &Status{Version: 1, GlobalStats: make(map[string]string)}
+	section := ""
+	for _, line := range lines[1:] {
+		switch line {
+		case "ROUTING TABLE", "GLOBAL STATS":
+			section = line
+			continue
+		case "END":
+			return st, nil
+		}
+		fields := strings.Split(line, ",")
+		if fields[0] == "Updated" && len(fields) > 1 {
+			st.Updated = parseStatusTime(fields[1], "")
+			continue
+		}
+		switch section {
+		case "":
+			if fields[0] == "Common Name" || len(fields) < 5 {
+				continue
+			}
+			client := StatusClient{CommonName: fields[0], RealAddress: fields[1], ConnectedSince: parseStatusTime(fields[4], "")}
+			var err error
+			if client.BytesReceived, err = strconv.ParseUint(fields[2], 10, 64); err != nil {
+				return nil, err
+			}
+			if client.BytesSent, err = strconv.ParseUint(fields[3], 10, 64); err != nil {
+				return nil, err
+			}
+			st.Clients = append(st.Clients, client)
+		case "ROUTING TABLE":
+			if fields[0] == "Virtual Address" || len(fields) < 4 {
+				continue
+			}
+			st.Routes = append(st.Routes, StatusRoute{VirtualAddress: fields[0], CommonName: fields[1], RealAddress: fields[2], LastRef: parseStatusTime(fields[3], "")})
+		case "GLOBAL STATS":
+			if len(fields) == 2 {
+				st.GlobalStats[fields[0]] = fields[1]
+			}
+		}
+	}
+	return st, nil
+}
+
+func parseStatusV23(lines []string, sep string, version int) (*Status, error) {
+	st := &Status{Version: version, GlobalStats: make(map[string]string)}
+	headers := make(map[string]map[string]int)
+	for _, line := range lines {
+		fields := strings.Split(line, sep)
+		get := func(header, column string) string {
+			idx, ok := headers[header][column]
+			if !ok || idx >= len(fields) {
+				return ""
+			}
+			return fields[idx]
+		}
+		switch fields[0] {
+		case "END":
+			return st, nil
+		case "TITLE":
+			if len(fields) > 1 {
+				st.Title = fields[1]
+			}
+		case "TIME":
+			if len(fields) > 2 {
+				st.Updated = parseStatusTime(fields[1], fields[2])
+			} else if len(fields) > 1 {
+				st.Updated = parseStatusTime(fields[1], "")
+			}
+		case "HEADER":
+			if len(fields) < 2 {
+				continue
+			}
+			columns := make(map[string]int)
+			for i, name := range fields[2:] {
+				columns[name] = i + 1
+			}
+			headers[fields[1]] = columns
+		case "CLIENT_LIST":
+			client := StatusClient{
+				CommonName:         get("CLIENT_LIST", "Common Name"),
+				RealAddress:        get("CLIENT_LIST", "Real Address"),
+				VirtualAddress:     get("CLIENT_LIST", "Virtual Address"),
+				VirtualIPv6Address: get("CLIENT_LIST", "Virtual IPv6 Address"),
+				ConnectedSince:     parseStatusTime(get("CLIENT_LIST", "Connected Since"), get("CLIENT_LIST", "Connected Since (time_t)")),
+				Username:           get("CLIENT_LIST", "Username"),
+			}
+			var err error
+			if client.BytesReceived, err = strconv.ParseUint(get("CLIENT_LIST", "Bytes Received"), 10, 64); err != nil {
+				return nil, err
+			}
+			if client.BytesSent, err = strconv.ParseUint(get("CLIENT_LIST", "Bytes Sent"), 10, 64); err != nil {
+				return nil, err
+			}
+			st.Clients = append(st.Clients, client)
+		case "ROUTING_TABLE":
+			st.Routes = append(st.Routes, StatusRoute{
+				VirtualAddress: get("ROUTING_TABLE", "Virtual Address"),
+				CommonName:     get("ROUTING_TABLE", "Common Name"),
+				RealAddress:    get("ROUTING_TABLE", "Real Address"),
+				LastRef:        parseStatusTime(get("ROUTING_TABLE", "Last Ref"), get("ROUTING_TABLE", "Last Ref (time_t)")),
+			})
+		case "GLOBAL_STATS":
+			if len(fields) == 3 {
+				st.GlobalStats[fields[1]] = fields[2]
+			}
+		}
+	}
+	return st, nil
+}
+
+// Fill virtual addresses of clients from routing table (version 1 has no addresses in client list)
+func (st *Status) fillVirtualAddresses() {
+	for i := range st.Clients {
+		client := &st.Clients[i]
+		for _, route := range st.Routes {
+			if route.CommonName != client.CommonName || route.RealAddress != client.RealAddress {
+				continue
+			}
+			ip := net.ParseIP(route.VirtualAddress)
+			if ip == nil {
+				continue // subnet (iroute) or MAC address (tap)
+			}
+			if ip.To4() != nil {
+				if client.VirtualAddress == "" {
+					client.VirtualAddress = route.VirtualAddress
+				}
+			} else if client.VirtualIPv6Address == "" {
+				client.VirtualIPv6Address = route.VirtualAddress
+			}
+		}
+	}
+}
+
+// Parse time from unix timestamp (if set) or text representation in local time zone
+func parseStatusTime(text, unix string) time.Time {
+	if unix != "" {
+		if sec, err := strconv.ParseInt(unix, 10, 64); err == nil {
+			return time.Unix(sec, 0)
+		}
+	}
+	for _, layout := range []string{time.ANSIC, "2006-01-02 15:04:05"} {
+		if t, err := time.ParseInLocation(layout, text, time.Local); err == nil {
+			return t
+		}
+	}
+	return time.Time{}
+}
@@ -0,0 +1,118 @@
+package vpnc
+import (
+	"testing"
+	"strings"
+	"time"
+	"io/ioutil"
+	"os"
+	"path"
+	"net/http/httptest"
+)
+
+const testStatusV1 = `OpenVPN CLIENT LIST
+Updated,Thu Jun 18 08:12:15 2015
+Common Name,Real Address,Bytes Received,Bytes Sent,Connected Since
+ivan,1.2.3.4:51234,1234,5678,Thu Jun 18 04:23:03 2015
+petr,5.6.7.8:1194,10,20,Thu Jun 18 05:00:00 2015
+ROUTING TABLE
+Virtual Address,Common Name,Real Address,Last Ref
+10.8.0.6,ivan,1.2.3.4:51234,Thu Jun 18 08:12:09 2015
+fd00::1000,ivan,1.2.3.4:51234,Thu Jun 18 08:12:09 2015
+10.8.0.10,petr,5.6.7.8:1194,Thu Jun 18 08:10:00 2015
+GLOBAL STATS
+Max bcast/mcast queue length,0
+END
+`
+
+const testStatusV2 = `TITLE,OpenVPN 2.4.4 x86_64-pc-linux-gnu
+TIME,Thu Jun 18 08:12:15 2015,1434615135
+HEADER,CLIENT_LIST,Common Name,Real Address,Virtual Address,Virtual IPv6 Address,Bytes Received,Bytes Sent,Connected Since,Connected Since (time_t),Username,Client ID,Peer ID
+CLIENT_LIST,ivan,1.2.3.4:51234,10.8.0.6,fd00::1000,1234,5678,Thu Jun 18 04:23:03 2015,1434601383,UNDEF,0,0
+HEADER,ROUTING_TABLE,Virtual Address,Common Name,Real Address,Last Ref,Last Ref (time_t)
+ROUTING_TABLE,10.8.0.6,ivan,1.2.3.4:51234,Thu Jun 18 08:12:09 2015,1434615129
+GLOBAL_STATS,Max bcast/mcast queue length,0
+END
+`
+
+func TestParseStatusV1(t *testing.T) {
+	st, err := ParseStatus(strings.NewReader(testStatusV1))
+	if err != nil {
+		t.Fatal("Parse status", err)
+	}
+	if st.Version != 1 || len(st.Clients) != 2 || len(st.Routes) != 3 {
+		t.Fatal("Bad status", st)
+	}
+	ivan := st.Client("ivan")
+	if ivan == nil {
+		t.Fatal("Client not found")
+	}
+	if ivan.RealAddress != "1.2.3.4:51234" || ivan.VirtualAddress != "10.8.0.6" || ivan.VirtualIPv6Address != "fd00::1000" ||
+		ivan.BytesReceived != 1234 || ivan.BytesSent != 5678 {
+		t.Error("Bad client", ivan)
+	}
+	if ivan.ConnectedSince.Hour() != 4 || ivan.ConnectedSince.Year() != 2015 {
+		t.Error("Bad connected since", ivan.ConnectedSince)
+	}
+	if st.GlobalStats["Max bcast/mcast queue length"] != "0" {
+		t.Error("Global stats not parsed")
+	}
+}
+
+func TestParseStatusV2V3(t *testing.T) {
+	for version, text := range map[int]string{2: testStatusV2, 3: strings.Replace(testStatusV2, ",", "\t", -1)} {
+		st, err := ParseStatus(strings.NewReader(text))
+		if err != nil {
+			t.Fatal("Parse status", version, err)
+		}
+		if st.Version != version || st.Title != "OpenVPN 2.4.4 x86_64-pc-linux-gnu" || !st.Updated.Equal(time.Unix(1434615135, 0)) {
+			t.Error("Bad status header", version, st)
+		}
+		if len(st.Clients) != 1 || len(st.Routes) != 1 {
+			t.Fatal("Bad status", version, st)
+		}
+		ivan := st.Clients[0]
+		if ivan.CommonName != "ivan" || ivan.VirtualAddress != "10.8.0.6" || ivan.VirtualIPv6Address != "fd00::1000" ||
+			ivan.BytesReceived != 1234 || ivan.BytesSent != 5678 || !ivan.ConnectedSince.Equal(time.Unix(1434601383, 0)) {
+			t.Error("Bad client", version, ivan)
+		}
+	}
+}
+
+func TestParseStatusUnknown(t *testing.T) {
+	if _, err := ParseStatus(strings.NewReader("garbage\n")); err == nil {
+		t.Error("Unknown format must fail")
+	}
+}
+
+func TestReadStatusRelative(t *testing.T) {
+	dir, err := ioutil.TempDir("", "status")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err = ioutil.WriteFile(path.Join(dir, "openvpn-status.log"), []byte(testStatusV2), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err = ioutil.WriteFile(path.Join(dir, "server.conf"), []byte("port 1194\nproto udp\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	ovpn, err := OpenServerConf(path.Join(dir, "server.conf"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ovpn.StatusPath() != path.Join(dir, "openvpn-status.log") {
+		t.Error("Default status file not resolved against config directory", ovpn.StatusPath())
+	}
+	if _, err = ovpn.ReadStatus(); err != nil {
+		t.Error("Read status of server", err)
+	}
+	rec := httptest.NewRecorder()
+	Exporter{Server: ovpn}.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if !strings.Contains(rec.Body.String(), "openvpn_status_up 1\n") {
+		t.Error("Exporter must read status file of server", rec.Body.String())
+	}
+	noDir := OpenVPNServer{StatusFile: "status.log", PersistIPFile: path.Join(dir, "ipp.txt")}
+	if noDir.StatusPath() != "status.log" {
+		t.Error("Status file resolved without ConfigDir", noDir.StatusPath())
+	}
+}